@@ -0,0 +1,377 @@
+package framestream
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// default acknowledgement policy of the receiver
+const (
+	DefaultAckFrames      = 100
+	DefaultAckInterval    = time.Second
+	DefaultAckHoldTimeout = 30 * time.Second
+	DefaultAckMaxHeld     = 10000
+)
+
+var ErrAckQueueFull = errors.New("acknowledgement queue full")
+var ErrAckGapSkipped = errors.New("frames never confirmed acknowledged")
+var ErrSequenceMissing = errors.New("data frame without sequence number")
+var ErrAckNotConfirmed = errors.New("acknowledgements need RecvFrame and Ack")
+
+/*
+Acknowledgement
+
+Once negotiated, each data frame starts with its 8 bytes sequence number
+and the receiver acknowledges with ACK control frames the highest sequence
+up to which every frame was handled, confirmed by the application with Ack.
+Frames not acknowledged are kept by the sender in an AckQueue and sent again
+after the next handshake, delivery is at least once.
+*/
+
+type ackEntry struct {
+	seq     uint64
+	payload []byte
+}
+
+/* Frames waiting for acknowledgement, shared across the sessions of a sender */
+type AckQueue struct {
+	mu         sync.Mutex
+	maxPending int
+	next       uint64
+	acked      uint64
+	entries    []ackEntry
+}
+
+// NewAckQueue creates a queue holding up to maxPending frames,
+// unlimited when zero
+func NewAckQueue(maxPending int) *AckQueue {
+	return &AckQueue{maxPending: maxPending, next: 1}
+}
+
+// Pending returns the number of frames not acknowledged yet
+func (q *AckQueue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// Acked returns the highest sequence number acknowledged
+func (q *AckQueue) Acked() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.acked
+}
+
+func (q *AckQueue) push(payload []byte) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxPending > 0 && len(q.entries) >= q.maxPending {
+		return 0, ErrAckQueueFull
+	}
+	seq := q.next
+	q.next++
+	q.entries = append(q.entries, ackEntry{seq: seq, payload: append([]byte{}, payload...)})
+	return seq, nil
+}
+
+func (q *AckQueue) ack(seq uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if seq <= q.acked {
+		return
+	}
+	q.acked = seq
+	i := 0
+	for i < len(q.entries) && q.entries[i].seq <= seq {
+		i++
+	}
+	q.entries = append(q.entries[:0], q.entries[i:]...)
+}
+
+func (q *AckQueue) pending() []ackEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]ackEntry{}, q.entries...)
+}
+
+type AckOptions struct {
+	// acknowledge once this number of frames is handled, DefaultAckFrames by default
+	Frames int
+	// acknowledge handled frames after this delay, DefaultAckInterval by default
+	Interval time.Duration
+	// time a frame never confirmed holds back the acknowledgement of the
+	// next ones before it is skipped, DefaultAckHoldTimeout by default
+	HoldTimeout time.Duration
+	// frames confirmed out of order held at most before the missing ones
+	// are skipped, DefaultAckMaxHeld by default
+	MaxHeld int
+}
+
+type ackResult struct {
+	ctrl *ControlFrame
+	err  error
+}
+
+type ackState struct {
+	enabled bool
+	active  bool
+	opts    AckOptions
+	// sender
+	queue *AckQueue
+	done  chan ackResult
+	// receiver
+	mu sync.Mutex
+	// first sequence of the session not handled yet, zero before the first frame
+	next uint64
+	// sequence ranges handled out of order, by first sequence
+	handled map[uint64]uint64
+	// since when the frames of handled are held back by a missing one
+	heldSince time.Time
+	// first sequence of the message being reassembled
+	fragmentSeq uint64
+	sent        uint64
+	count       int
+	timer       *time.Timer
+}
+
+// SetAckQueue offers on the sender the acknowledgement extension,
+// the frames written are kept in the queue until acknowledged
+func (fs *Fstrm) SetAckQueue(queue *AckQueue) {
+	fs.ack.queue = queue
+}
+
+// SetAcknowledgement accepts on the receiver the acknowledgement extension,
+// the frames confirmed with Ack are acknowledged in batches of frames or
+// after the interval
+func (fs *Fstrm) SetAcknowledgement(opts AckOptions) {
+	if opts.Frames <= 0 {
+		opts.Frames = DefaultAckFrames
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultAckInterval
+	}
+	if opts.HoldTimeout <= 0 {
+		opts.HoldTimeout = DefaultAckHoldTimeout
+	}
+	if opts.MaxHeld <= 0 {
+		opts.MaxHeld = DefaultAckMaxHeld
+	}
+	fs.ack.enabled, fs.ack.opts = true, opts
+}
+
+// AckActive reports whether the acknowledgement extension was negotiated
+func (fs *Fstrm) AckActive() bool {
+	return fs.ack.active
+}
+
+// length of the sequence number prefixed to data frames
+const sequenceLength = 8
+
+// withSequence returns a copy of the data frame prefixed with its sequence number
+func withSequence(frame *Frame, seq uint64) *Frame {
+	payload := frame.data[4:]
+	data := make([]byte, 4, len(frame.data)+sequenceLength)
+	binary.BigEndian.PutUint32(data, uint32(len(payload)+sequenceLength))
+	data = binary.BigEndian.AppendUint64(data, seq)
+	return &Frame{data: append(data, payload...), wire: true}
+}
+
+func decodeSequence(ctrl *ControlFrame) (uint64, bool) {
+	value, ok := ctrl.Field(CONTROL_FIELD_SEQUENCE)
+	if !ok || len(value) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(value), true
+}
+
+// startAcks sends again the frames not acknowledged by the previous
+// sessions and reads the acknowledgements in the background
+func (fs *Fstrm) startAcks() error {
+	fs.ack.done = make(chan ackResult, 1)
+	go fs.readAcks()
+
+	for _, entry := range fs.ack.queue.pending() {
+		frame := &Frame{}
+		frame.Write(entry.payload)
+		if err := fs.write(frame, entry.seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *Fstrm) readAcks() {
+	for {
+		ctrl, err := fs.recvControl(false)
+		if err == nil && ctrl.ctype == CONTROL_ACK {
+			if seq, ok := decodeSequence(ctrl); ok {
+				fs.ack.queue.ack(seq)
+			}
+			continue
+		}
+		fs.ack.done <- ackResult{ctrl: ctrl, err: err}
+		return
+	}
+}
+
+// recvFinish waits for the control frame ending the session, read
+// in the background once acknowledgements are active
+func (fs *Fstrm) recvFinish(timeout bool) (*ControlFrame, error) {
+	if fs.ack.done == nil {
+		return fs.recvControl(timeout)
+	}
+
+	var expired <-chan time.Time
+	if timeout && fs.readtimeout != 0 {
+		timer := time.NewTimer(fs.readtimeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case result := <-fs.ack.done:
+		return result.ctrl, result.err
+	case <-expired:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// Ack confirms that a data frame returned by RecvFrame was handled, once
+// acknowledgements are active. Frames are acknowledged to the sender in order,
+// a frame never confirmed holds back the acknowledgement of the next ones
+// until HoldTimeout or MaxHeld is reached, it is then acknowledged anyway
+// and reported to the warning handler with ErrAckGapSkipped. It does nothing
+// otherwise.
+func (fs *Fstrm) Ack(frame *Frame) {
+	if frame == nil || frame.ackTo == 0 {
+		return
+	}
+	fs.acknowledge(frame.ackFrom, frame.ackTo)
+}
+
+// ackReceived records the sequence of a frame read from the connection,
+// the first one starts the acknowledgements of the session
+func (fs *Fstrm) ackReceived(seq uint64) {
+	fs.ack.mu.Lock()
+	if fs.ack.next == 0 {
+		fs.ack.next = seq
+	}
+	fs.ack.mu.Unlock()
+}
+
+// acknowledge records the sequences of a handled or filtered frame
+// and acknowledges them when due
+func (fs *Fstrm) acknowledge(from, to uint64) {
+	fs.ack.mu.Lock()
+	if to < fs.ack.next {
+		fs.ack.mu.Unlock()
+		return
+	}
+	if fs.ack.handled == nil {
+		fs.ack.handled = make(map[uint64]uint64)
+	}
+	fs.ack.handled[from] = to
+	fs.advanceAck()
+	skipped := len(fs.ack.handled) > fs.ack.opts.MaxHeld
+	if skipped {
+		fs.skipAckGap()
+	}
+	fs.ack.count++
+	due := fs.ack.count >= fs.ack.opts.Frames
+	fs.ack.mu.Unlock()
+
+	if skipped {
+		fs.warn(ErrAckGapSkipped)
+	}
+	if due {
+		fs.flushAck()
+	}
+	fs.scheduleAck()
+}
+
+// advanceAck moves the next sequence over the frames handled contiguously,
+// the lock must be held
+func (fs *Fstrm) advanceAck() {
+	for {
+		last, ok := fs.ack.handled[fs.ack.next]
+		if !ok {
+			break
+		}
+		delete(fs.ack.handled, fs.ack.next)
+		fs.ack.next = last + 1
+	}
+	if len(fs.ack.handled) == 0 {
+		fs.ack.heldSince = time.Time{}
+	} else if fs.ack.heldSince.IsZero() {
+		fs.ack.heldSince = time.Now()
+	}
+}
+
+// skipAckGap gives up on the frames missing before the first held one,
+// the lock must be held
+func (fs *Fstrm) skipAckGap() {
+	first := uint64(0)
+	for from := range fs.ack.handled {
+		if first == 0 || from < first {
+			first = from
+		}
+	}
+	fs.ack.next, fs.ack.heldSince = first, time.Time{}
+	fs.advanceAck()
+}
+
+// scheduleAck starts the timer acknowledging the frames handled,
+// and skipping a gap held back too long
+func (fs *Fstrm) scheduleAck() {
+	fs.ack.mu.Lock()
+	defer fs.ack.mu.Unlock()
+	if fs.ack.timer != nil || (fs.ack.count == 0 && fs.ack.heldSince.IsZero()) {
+		return
+	}
+	delay := fs.ack.opts.Interval
+	if !fs.ack.heldSince.IsZero() {
+		delay = min(delay, time.Until(fs.ack.heldSince.Add(fs.ack.opts.HoldTimeout)))
+	}
+	fs.ack.timer = time.AfterFunc(delay, fs.expireAck)
+}
+
+func (fs *Fstrm) expireAck() {
+	fs.ack.mu.Lock()
+	fs.ack.timer = nil
+	skipped := !fs.ack.heldSince.IsZero() && time.Since(fs.ack.heldSince) >= fs.ack.opts.HoldTimeout
+	if skipped {
+		fs.skipAckGap()
+	}
+	fs.ack.mu.Unlock()
+
+	if skipped {
+		fs.warn(ErrAckGapSkipped)
+	}
+	fs.flushAck()
+	fs.scheduleAck()
+}
+
+// flushAck acknowledges the frames handled so far
+func (fs *Fstrm) flushAck() error {
+	fs.ack.mu.Lock()
+	if fs.ack.timer != nil {
+		fs.ack.timer.Stop()
+		fs.ack.timer = nil
+	}
+	if fs.ack.next == 0 {
+		fs.ack.mu.Unlock()
+		return nil
+	}
+	seq := fs.ack.next - 1
+	if seq <= fs.ack.sent {
+		fs.ack.mu.Unlock()
+		return nil
+	}
+	fs.ack.sent, fs.ack.count = seq, 0
+	fs.ack.mu.Unlock()
+
+	ctrl := &ControlFrame{ctype: CONTROL_ACK, fields: []ControlField{{Type: CONTROL_FIELD_SEQUENCE, Value: binary.BigEndian.AppendUint64(nil, seq)}}}
+	return fs.SendControl(ctrl)
+}
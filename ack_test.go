@@ -0,0 +1,283 @@
+package framestream
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// ackSession connects a sender using the queue, unacknowledged frames
+// are sent again by InitSender while the test reads them
+func ackSession(t *testing.T, queue *AckQueue, opts *AckOptions) (*Fstrm, *Fstrm) {
+	sender, receiver, done, err := startPipeSession(t,
+		func(fs *Fstrm) { fs.SetAckQueue(queue) },
+		func(fs *Fstrm) {
+			if opts != nil {
+				fs.SetAcknowledgement(*opts)
+			}
+		})
+	if err != nil {
+		t.Fatalf("error to init framestream receiver: %s", err)
+	}
+	t.Cleanup(func() {
+		if err := <-done; err != nil {
+			t.Errorf("error to init framestream sender: %s", err)
+		}
+	})
+	return sender, receiver
+}
+
+// recvPayloads receives n data frames and confirms them as handled
+func recvPayloads(t *testing.T, fs *Fstrm, n int) [][]byte {
+	var payloads [][]byte
+	for i := 0; i < n; i++ {
+		frame, err := fs.RecvFrame(true)
+		if err != nil {
+			t.Fatalf("error to receive frame: %s", err)
+		}
+		payloads = append(payloads, frame.Payload())
+		fs.Ack(frame)
+	}
+	return payloads
+}
+
+func TestAck_Stop(t *testing.T) {
+	queue := NewAckQueue(0)
+	sender, receiver := ackSession(t, queue, &AckOptions{Frames: 2, Interval: time.Hour})
+	if !receiver.AckActive() {
+		t.Fatalf("acknowledgement not negotiated")
+	}
+
+	go func() {
+		for i := 0; i < 5; i++ {
+			sender.SendFrame(NewDataFrame([]byte{byte(i)}))
+		}
+		sender.ResetSender()
+	}()
+	payloads := recvPayloads(t, receiver, 5)
+	for i, payload := range payloads {
+		if !bytes.Equal(payload, []byte{byte(i)}) {
+			t.Errorf("unexpected payload %d: %v", i, payload)
+		}
+	}
+
+	stop, err := receiver.RecvFrame(true)
+	if err != nil || !stop.IsControl() {
+		t.Fatalf("expected stop control frame: %v", err)
+	}
+	receiver.ResetReceiver(stop)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for queue.Pending() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if queue.Pending() != 0 || queue.Acked() != 5 {
+		t.Errorf("expected all frames acknowledged, %d pending, acked %d", queue.Pending(), queue.Acked())
+	}
+}
+
+func TestAck_NotHandled(t *testing.T) {
+	queue := NewAckQueue(0)
+	sender, receiver := ackSession(t, queue, &AckOptions{Frames: 100, Interval: time.Hour})
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			sender.SendFrame(NewDataFrame([]byte{byte(i)}))
+		}
+		sender.ResetSender()
+	}()
+
+	// the second frame is read but never handled
+	var frames []*Frame
+	for i := 0; i < 3; i++ {
+		frame, err := receiver.RecvFrame(true)
+		if err != nil {
+			t.Fatalf("error to receive frame: %s", err)
+		}
+		frames = append(frames, frame)
+	}
+	receiver.Ack(frames[0])
+	receiver.Ack(frames[2])
+
+	stop, err := receiver.RecvFrame(true)
+	if err != nil || !stop.IsControl() {
+		t.Fatalf("expected stop control frame: %v", err)
+	}
+	receiver.ResetReceiver(stop)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for queue.Acked() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if queue.Acked() != 1 || queue.Pending() != 2 {
+		t.Errorf("expected frames from the unhandled one pending, %d pending, acked %d", queue.Pending(), queue.Acked())
+	}
+}
+
+func TestAck_HoldLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts AckOptions
+	}{
+		{name: "hold_timeout", opts: AckOptions{Frames: 100, Interval: time.Hour, HoldTimeout: 50 * time.Millisecond}},
+		{name: "max_held", opts: AckOptions{Frames: 1, Interval: time.Hour, HoldTimeout: time.Hour, MaxHeld: 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			queue := NewAckQueue(0)
+			sender, receiver := ackSession(t, queue, &tc.opts)
+			warnings := make(chan error, 10)
+			receiver.SetWarningHandler(func(err error) { warnings <- err })
+
+			go func() {
+				for i := 0; i < 3; i++ {
+					sender.SendFrame(NewDataFrame([]byte{byte(i)}))
+				}
+			}()
+
+			// the first frame is never handled
+			var frames []*Frame
+			for i := 0; i < 3; i++ {
+				frame, err := receiver.RecvFrame(true)
+				if err != nil {
+					t.Fatalf("error to receive frame: %s", err)
+				}
+				frames = append(frames, frame)
+			}
+			receiver.Ack(frames[1])
+			receiver.Ack(frames[2])
+
+			deadline := time.Now().Add(2 * time.Second)
+			for queue.Acked() != 3 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if queue.Acked() != 3 || queue.Pending() != 0 {
+				t.Errorf("expected the missing frame skipped, %d pending, acked %d", queue.Pending(), queue.Acked())
+			}
+			select {
+			case err := <-warnings:
+				if err != ErrAckGapSkipped {
+					t.Errorf("expected ErrAckGapSkipped, got %v", err)
+				}
+			default:
+				t.Errorf("skipped gap not reported")
+			}
+		})
+	}
+}
+
+func TestAck_ProcessFrame(t *testing.T) {
+	queue := NewAckQueue(0)
+	_, receiver := ackSession(t, queue, &AckOptions{})
+
+	if err := receiver.ProcessFrame(make(chan []byte, 1)); err != ErrAckNotConfirmed {
+		t.Errorf("expected ErrAckNotConfirmed, got %v", err)
+	}
+}
+
+func TestAck_Fragments(t *testing.T) {
+	queue := NewAckQueue(0)
+	sender, receiver := pipeSession(t,
+		func(fs *Fstrm) {
+			fs.SetAckQueue(queue)
+			fs.SetFragmentation(true)
+		},
+		func(fs *Fstrm) {
+			fs.SetAcknowledgement(AckOptions{Frames: 1, Interval: time.Hour})
+			fs.SetDataFrameMaxLength(32)
+			fs.SetFragmentation(true)
+		})
+
+	go sender.SendFrame(NewDataFrame(bytes.Repeat([]byte{1}, 100)))
+	frame, err := receiver.RecvFrame(true)
+	if err != nil {
+		t.Fatalf("error to receive frame: %s", err)
+	}
+	if queue.Acked() != 0 {
+		t.Fatalf("fragments acknowledged before the message is handled")
+	}
+
+	// every fragment is acknowledged with the message
+	fragments := uint64(queue.Pending())
+	receiver.Ack(frame)
+	deadline := time.Now().Add(2 * time.Second)
+	for queue.Pending() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fragments < 2 || queue.Acked() != fragments {
+		t.Errorf("expected %d fragments acknowledged, acked %d", fragments, queue.Acked())
+	}
+}
+
+func TestAck_Interval(t *testing.T) {
+	queue := NewAckQueue(0)
+	sender, receiver := ackSession(t, queue, &AckOptions{Frames: 100, Interval: 20 * time.Millisecond})
+
+	go sender.SendFrame(NewDataFrame([]byte{1}))
+	recvPayloads(t, receiver, 1)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for queue.Pending() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if queue.Acked() != 1 {
+		t.Errorf("expected acknowledgement after the interval, acked %d", queue.Acked())
+	}
+}
+
+func TestAck_Resend(t *testing.T) {
+	queue := NewAckQueue(0)
+	opts := &AckOptions{Frames: 100, Interval: time.Hour}
+
+	// first session dies before any acknowledgement
+	sender, receiver := ackSession(t, queue, opts)
+	go func() {
+		for i := 0; i < 3; i++ {
+			sender.SendFrame(NewDataFrame([]byte{byte(i)}))
+		}
+	}()
+	recvPayloads(t, receiver, 3)
+	sender.conn.Close()
+	receiver.conn.Close()
+	if queue.Pending() != 3 {
+		t.Fatalf("expected 3 pending frames, got %d", queue.Pending())
+	}
+
+	// reconnection
+	sender, receiver = ackSession(t, queue, opts)
+	go sender.SendFrame(NewDataFrame([]byte{3}))
+	payloads := recvPayloads(t, receiver, 4)
+	for i, payload := range payloads {
+		if !bytes.Equal(payload, []byte{byte(i)}) {
+			t.Errorf("unexpected payload %d: %v", i, payload)
+		}
+	}
+}
+
+func TestAck_Legacy(t *testing.T) {
+	queue := NewAckQueue(0)
+	sender, receiver := ackSession(t, queue, nil)
+	if sender.AckActive() || receiver.AckActive() {
+		t.Fatalf("acknowledgement active without receiver support")
+	}
+
+	go sender.SendFrame(NewDataFrame([]byte{1, 2}))
+	if payload := recvPayloads(t, receiver, 1)[0]; !bytes.Equal(payload, []byte{1, 2}) {
+		t.Errorf("unexpected payload: %v", payload)
+	}
+	if queue.Pending() != 0 {
+		t.Errorf("frames queued without acknowledgement")
+	}
+}
+
+func TestAckQueue_Full(t *testing.T) {
+	queue := NewAckQueue(2)
+	queue.push([]byte{1})
+	queue.push([]byte{2})
+	if _, err := queue.push([]byte{3}); err != ErrAckQueueFull {
+		t.Errorf("expected ErrAckQueueFull, got %v", err)
+	}
+	queue.ack(1)
+	if queue.Pending() != 1 || queue.Acked() != 1 {
+		t.Errorf("unexpected queue state: %d pending, acked %d", queue.Pending(), queue.Acked())
+	}
+}
@@ -0,0 +1,128 @@
+package framestream
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+const authNonceLength = 32
+
+var ErrAuthentication = errors.New("authentication failed")
+
+/* Authentication error, matches ErrAuthentication */
+type AuthError struct {
+	KeyID  string
+	Reason string
+}
+
+func (e *AuthError) Error() string {
+	if e.KeyID == "" {
+		return fmt.Sprintf("%s: %s", ErrAuthentication, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s (key id %q)", ErrAuthentication, e.Reason, e.KeyID)
+}
+
+func (e *AuthError) Is(target error) bool {
+	return target == ErrAuthentication
+}
+
+/*
+Pre-shared key authentication
+
+The sender offers its key id in READY, the receiver answers with a random
+nonce in ACCEPT and the sender proves the knowledge of the key with an
+HMAC-SHA256 over the nonce and the content type in START.
+*/
+type authState struct {
+	// sender
+	keyID string
+	key   []byte
+	// receiver
+	lookup func(keyID string) ([]byte, bool)
+	nonce  []byte
+	// authenticated key id
+	peer string
+}
+
+// SetAuthentication enables on the sender the pre-shared key authentication
+// with the given key id, it requires the bidirectional handshake
+func (fs *Fstrm) SetAuthentication(keyID string, key []byte) {
+	fs.auth.keyID = keyID
+	fs.auth.key = key
+}
+
+// SetAuthenticator requires on the receiver the pre-shared key authentication,
+// lookup returns the key of a key id. Senders that fail to authenticate are
+// disconnected before any data frame is accepted.
+func (fs *Fstrm) SetAuthenticator(lookup func(keyID string) ([]byte, bool)) {
+	fs.auth.lookup = lookup
+}
+
+func authMAC(key, nonce, ctype []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(nonce)
+	mac.Write(ctype)
+	return mac.Sum(nil)
+}
+
+// authChallenge checks the key id offered in READY and returns the nonce field for ACCEPT
+func (fs *Fstrm) authChallenge(ready *ControlFrame) (ControlField, error) {
+	keyID, ok := ready.Field(CONTROL_FIELD_AUTH_KEY_ID)
+	if !ok {
+		return ControlField{}, &AuthError{Reason: "no credentials offered"}
+	}
+	if _, ok := fs.auth.lookup(string(keyID)); !ok {
+		return ControlField{}, &AuthError{KeyID: string(keyID), Reason: "unknown key id"}
+	}
+	fs.auth.peer = string(keyID)
+	fs.auth.nonce = make([]byte, authNonceLength)
+	if _, err := rand.Read(fs.auth.nonce); err != nil {
+		return ControlField{}, err
+	}
+	return ControlField{Type: CONTROL_FIELD_AUTH_NONCE, Value: fs.auth.nonce}, nil
+}
+
+// authResponse returns the HMAC field for START, when challenged by the receiver
+func (fs *Fstrm) authResponse(accept *ControlFrame) []ControlField {
+	if fs.auth.key == nil {
+		return nil
+	}
+	nonce, ok := accept.Field(CONTROL_FIELD_AUTH_NONCE)
+	if !ok {
+		return nil
+	}
+	return []ControlField{{Type: CONTROL_FIELD_AUTH_HMAC, Value: authMAC(fs.auth.key, nonce, fs.ctype)}}
+}
+
+// authVerify checks the HMAC of START
+func (fs *Fstrm) authVerify(start *ControlFrame) error {
+	if fs.auth.nonce == nil {
+		return &AuthError{Reason: "handshake without authentication"}
+	}
+	// the key is looked up again, it may have been revoked meanwhile
+	key, ok := fs.auth.lookup(fs.auth.peer)
+	if !ok {
+		return &AuthError{KeyID: fs.auth.peer, Reason: "unknown key id"}
+	}
+	value, _ := start.Field(CONTROL_FIELD_AUTH_HMAC)
+	var ctype []byte
+	if len(start.ctypes) > 0 {
+		ctype = start.ctypes[0]
+	}
+	if !hmac.Equal(value, authMAC(key, fs.auth.nonce, ctype)) {
+		return &AuthError{KeyID: fs.auth.peer, Reason: "invalid hmac"}
+	}
+	return nil
+}
+
+// authFailed closes the connection of a sender failing to authenticate
+func (fs *Fstrm) authFailed(err error) error {
+	fs.auth.peer = ""
+	if fs.conn != nil {
+		fs.conn.Close()
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package framestream
+
+import (
+	"errors"
+	"testing"
+)
+
+func authSession(t *testing.T, keyID string, key []byte, handshake bool) (*Fstrm, error) {
+	keys := map[string][]byte{"collector-1": []byte("secret")}
+	// the sender fails on the closed connection when rejected
+	_, receiver, _, err := startPipeSession(t,
+		func(fs *Fstrm) {
+			if !handshake {
+				fs.SetHandshakeMode(HandshakeUnidirectional)
+			}
+			if key != nil {
+				fs.SetAuthentication(keyID, key)
+			}
+		},
+		func(fs *Fstrm) {
+			fs.SetHandshakeMode(HandshakeAuto)
+			fs.SetAuthenticator(func(keyID string) ([]byte, bool) {
+				key, ok := keys[keyID]
+				return key, ok
+			})
+		})
+	return receiver, err
+}
+
+func TestAuth_Success(t *testing.T) {
+	receiver, err := authSession(t, "collector-1", []byte("secret"), true)
+	if err != nil {
+		t.Fatalf("error to init framestream receiver: %s", err)
+	}
+	if keyID := receiver.SessionInfo().AuthKeyID; keyID != "collector-1" {
+		t.Errorf("unexpected authenticated key id: %q", keyID)
+	}
+}
+
+func TestAuth_Failures(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyID     string
+		key       []byte
+		handshake bool
+		reason    string
+	}{
+		{name: "invalid key", keyID: "collector-1", key: []byte("wrong"), handshake: true, reason: "invalid hmac"},
+		{name: "unknown key id", keyID: "collector-2", key: []byte("secret"), handshake: true, reason: "unknown key id"},
+		{name: "no credentials", handshake: true, reason: "no credentials offered"},
+		{name: "unidirectional", keyID: "collector-1", key: []byte("secret"), handshake: false, reason: "handshake without authentication"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			receiver, err := authSession(t, tc.keyID, tc.key, tc.handshake)
+			if !errors.Is(err, ErrAuthentication) {
+				t.Fatalf("expected authentication error, got %v", err)
+			}
+			var authErr *AuthError
+			if !errors.As(err, &authErr) || authErr.Reason != tc.reason {
+				t.Errorf("unexpected authentication error: %v", err)
+			}
+			if receiver.SessionInfo().AuthKeyID != "" {
+				t.Errorf("key id reported after a failed authentication")
+			}
+
+			// the connection is closed
+			if _, err := receiver.RecvFrame(false); err == nil {
+				t.Errorf("expected error on closed connection")
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package framestream
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoEndpointAvailable = errors.New("no endpoint available")
+var ErrBalancerClosed = errors.New("balancer closed")
+
+/* Error of a frame no endpoint accepted, with the last write error */
+type EndpointError struct {
+	Address string
+	Err     error
+}
+
+func (e *EndpointError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrNoEndpointAvailable, e.Address, e.Err)
+}
+
+func (e *EndpointError) Is(target error) bool {
+	return target == ErrNoEndpointAvailable
+}
+
+func (e *EndpointError) Unwrap() error {
+	return e.Err
+}
+
+/* Load balancing policy */
+type BalancePolicy int
+
+const (
+	BalanceRoundRobin BalancePolicy = iota
+	// BalanceLeastPending picks the endpoint with the fewest writes in
+	// flight, waiting ones included; ties are broken round robin so that
+	// a single sender still spreads its frames
+	BalanceLeastPending
+	BalanceConsistentHash
+)
+
+// virtual nodes per endpoint on the consistent hash ring
+const balancerRingReplicas = 100
+
+type BalancerOptions struct {
+	Network     string
+	Addresses   []string
+	ContentType []byte
+	Handshake   bool
+	ReadTimeout time.Duration
+	DialTimeout time.Duration
+	Policy      BalancePolicy
+	// interval between reconnection attempts of ejected endpoints
+	ProbeInterval time.Duration
+	// custom dialer, net.DialTimeout by default
+	Dial func(network, address string) (net.Conn, error)
+	// TCP keepalive of the sessions, when enabled
+	KeepAlive net.KeepAliveConfig
+}
+
+type endpoint struct {
+	address string
+	mu      sync.Mutex
+	conn    net.Conn
+	fs      *Fstrm
+	healthy atomic.Bool
+	pending atomic.Int64
+}
+
+type ringPoint struct {
+	hash     uint32
+	endpoint *endpoint
+}
+
+/* Sender distributing frames across several collectors */
+type Balancer struct {
+	opts      BalancerOptions
+	endpoints []*endpoint
+	ring      []ringPoint
+	next      atomic.Uint64
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewBalancer connects to every address, endpoints failing the handshake
+// are ejected and probed again every ProbeInterval
+func NewBalancer(opts BalancerOptions) (*Balancer, error) {
+	if len(opts.Addresses) == 0 {
+		return nil, ErrNoEndpointAvailable
+	}
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.ProbeInterval <= 0 {
+		opts.ProbeInterval = 5 * time.Second
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.Dial == nil {
+		opts.Dial = func(network, address string) (net.Conn, error) {
+			return net.DialTimeout(network, address, opts.DialTimeout)
+		}
+	}
+
+	b := &Balancer{opts: opts, done: make(chan struct{})}
+	for _, address := range opts.Addresses {
+		ep := &endpoint{address: address}
+		b.endpoints = append(b.endpoints, ep)
+		for i := 0; i < balancerRingReplicas; i++ {
+			b.ring = append(b.ring, ringPoint{hash: hashKey([]byte(address + "#" + strconv.Itoa(i))), endpoint: ep})
+		}
+		b.connect(ep)
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+
+	b.wg.Add(1)
+	go b.probe()
+	return b, nil
+}
+
+func hashKey(key []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(key)
+	return h.Sum32()
+}
+
+// connect dials the endpoint and runs the sender handshake
+func (b *Balancer) connect(ep *endpoint) error {
+	conn, err := b.opts.Dial(b.opts.Network, ep.address)
+	if err != nil {
+		return err
+	}
+
+	fs := NewFstrm(bufio.NewReader(conn), bufio.NewWriter(conn), conn, b.opts.ReadTimeout, b.opts.ContentType, b.opts.Handshake)
+	if b.opts.KeepAlive.Enable {
+		fs.SetKeepAlive(b.opts.KeepAlive)
+	}
+	if err := fs.InitSender(); err != nil {
+		conn.Close()
+		return err
+	}
+
+	ep.mu.Lock()
+	ep.conn, ep.fs = conn, fs
+	ep.mu.Unlock()
+	ep.healthy.Store(true)
+	return nil
+}
+
+// eject closes the connection of a failing endpoint
+func (b *Balancer) eject(ep *endpoint) {
+	ep.healthy.Store(false)
+	if ep.conn != nil {
+		ep.conn.Close()
+		ep.conn, ep.fs = nil, nil
+	}
+}
+
+func (b *Balancer) probe() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.opts.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			for _, ep := range b.endpoints {
+				if !ep.healthy.Load() {
+					b.connect(ep)
+				}
+			}
+		}
+	}
+}
+
+// pick returns the healthy endpoints in the order to try
+func (b *Balancer) pick(key []byte) []*endpoint {
+	candidates := make([]*endpoint, 0, len(b.endpoints))
+
+	switch b.opts.Policy {
+	case BalanceConsistentHash:
+		h := hashKey(key)
+		start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+		seen := make(map[*endpoint]bool, len(b.endpoints))
+		for i := 0; i < len(b.ring) && len(seen) < len(b.endpoints); i++ {
+			ep := b.ring[(start+i)%len(b.ring)].endpoint
+			if !seen[ep] {
+				seen[ep] = true
+				candidates = append(candidates, ep)
+			}
+		}
+
+	case BalanceLeastPending:
+		candidates = b.roundRobin(candidates)
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].pending.Load() < candidates[j].pending.Load()
+		})
+
+	default:
+		candidates = b.roundRobin(candidates)
+	}
+
+	healthy := candidates[:0]
+	for _, ep := range candidates {
+		if ep.healthy.Load() {
+			healthy = append(healthy, ep)
+		}
+	}
+	return healthy
+}
+
+// roundRobin appends the endpoints, starting after the last one picked
+func (b *Balancer) roundRobin(candidates []*endpoint) []*endpoint {
+	start := int(b.next.Add(1)-1) % len(b.endpoints)
+	for i := range b.endpoints {
+		candidates = append(candidates, b.endpoints[(start+i)%len(b.endpoints)])
+	}
+	return candidates
+}
+
+func (b *Balancer) SendFrame(frame *Frame) error {
+	return b.SendFrameKey(nil, frame)
+}
+
+// SendFrameKey sends the frame to the endpoint selected by the policy,
+// the key is only used by the consistent hash policy. Endpoints failing
+// the write are ejected and the next one is tried. When all of them fail,
+// an EndpointError holds the last write error.
+func (b *Balancer) SendFrameKey(key []byte, frame *Frame) error {
+	select {
+	case <-b.done:
+		return ErrBalancerClosed
+	default:
+	}
+
+	var last *EndpointError
+	for _, ep := range b.pick(key) {
+		ep.pending.Add(1)
+		err := b.send(ep, frame)
+		ep.pending.Add(-1)
+		if err == nil {
+			return nil
+		}
+		last = &EndpointError{Address: ep.address, Err: err}
+	}
+	if last != nil {
+		return last
+	}
+	return ErrNoEndpointAvailable
+}
+
+func (b *Balancer) send(ep *endpoint, frame *Frame) error {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	if ep.fs == nil {
+		return ErrNoEndpointAvailable
+	}
+	if err := ep.fs.SendFrame(frame); err != nil {
+		b.eject(ep)
+		return err
+	}
+	return nil
+}
+
+// Healthy returns the addresses of the endpoints in use
+func (b *Balancer) Healthy() []string {
+	var addresses []string
+	for _, ep := range b.endpoints {
+		if ep.healthy.Load() {
+			addresses = append(addresses, ep.address)
+		}
+	}
+	return addresses
+}
+
+// Close stops the sessions of all endpoints
+func (b *Balancer) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		close(b.done)
+		b.wg.Wait()
+		for _, ep := range b.endpoints {
+			ep.mu.Lock()
+			if ep.fs != nil {
+				if rerr := ep.fs.ResetSender(); rerr != nil && err == nil {
+					err = rerr
+				}
+			}
+			b.eject(ep)
+			ep.mu.Unlock()
+		}
+	})
+	return err
+}
@@ -0,0 +1,204 @@
+package framestream
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fake collectors reachable through the dial function of the balancer
+type fakeCollectors struct {
+	mu       sync.Mutex
+	down     map[string]bool
+	conns    map[string]net.Conn
+	received map[string]int
+}
+
+func newFakeCollectors() *fakeCollectors {
+	return &fakeCollectors{down: map[string]bool{}, conns: map[string]net.Conn{}, received: map[string]int{}}
+}
+
+func (fc *fakeCollectors) dial(network, address string) (net.Conn, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.down[address] {
+		return nil, errors.New("connection refused")
+	}
+
+	client, server := net.Pipe()
+	fc.conns[address] = server
+	go func() {
+		defer server.Close()
+		fs := NewFstrm(bufio.NewReader(server), bufio.NewWriter(server), server, time.Second, []byte("ctype"), true)
+		if err := fs.InitReceiver(); err != nil {
+			return
+		}
+		for {
+			frame, err := fs.RecvFrame(false)
+			if err != nil {
+				return
+			}
+			if frame.IsControl() {
+				fs.ResetReceiver(frame)
+				return
+			}
+			fc.mu.Lock()
+			fc.received[address]++
+			fc.mu.Unlock()
+		}
+	}()
+	return client, nil
+}
+
+// kill closes the connection of a collector and refuses new ones
+func (fc *fakeCollectors) kill(address string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.down[address] = true
+	fc.conns[address].Close()
+}
+
+func (fc *fakeCollectors) revive(address string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.down[address] = false
+}
+
+func (fc *fakeCollectors) count(address string) int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.received[address]
+}
+
+func newTestBalancer(t *testing.T, fc *fakeCollectors, policy BalancePolicy) *Balancer {
+	b, err := NewBalancer(BalancerOptions{
+		Addresses:     []string{"a", "b", "c"},
+		ContentType:   []byte("ctype"),
+		Handshake:     true,
+		ReadTimeout:   time.Second,
+		Policy:        policy,
+		ProbeInterval: 10 * time.Millisecond,
+		Dial:          fc.dial,
+	})
+	if err != nil {
+		t.Fatalf("error to create balancer: %s", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func testFrame() *Frame {
+	frame := &Frame{}
+	frame.Write([]byte{1, 2, 3, 4})
+	return frame
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not reached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBalancer_RoundRobin(t *testing.T) {
+	fc := newFakeCollectors()
+	b := newTestBalancer(t, fc, BalanceRoundRobin)
+
+	for i := 0; i < 9; i++ {
+		if err := b.SendFrame(testFrame()); err != nil {
+			t.Fatalf("error to send frame: %s", err)
+		}
+	}
+	for _, address := range []string{"a", "b", "c"} {
+		waitFor(t, func() bool { return fc.count(address) == 3 })
+	}
+}
+
+func TestBalancer_ConsistentHash(t *testing.T) {
+	fc := newFakeCollectors()
+	b := newTestBalancer(t, fc, BalanceConsistentHash)
+
+	key := []byte("client-10.0.0.1")
+	first := b.pick(key)[0]
+	for i := 0; i < 5; i++ {
+		if err := b.SendFrameKey(key, testFrame()); err != nil {
+			t.Fatalf("error to send frame: %s", err)
+		}
+	}
+	waitFor(t, func() bool { return fc.count(first.address) == 5 })
+}
+
+func TestBalancer_LeastPending(t *testing.T) {
+	fc := newFakeCollectors()
+	b := newTestBalancer(t, fc, BalanceLeastPending)
+
+	b.endpoints[0].pending.Add(2)
+	b.endpoints[1].pending.Add(1)
+	if ep := b.pick(nil)[0]; ep.address != "c" {
+		t.Errorf("expected endpoint c, got %s", ep.address)
+	}
+
+	// no write in flight, spread round robin
+	b.endpoints[0].pending.Add(-2)
+	b.endpoints[1].pending.Add(-1)
+	for i := 0; i < 6; i++ {
+		if err := b.SendFrame(testFrame()); err != nil {
+			t.Fatalf("error to send frame: %s", err)
+		}
+	}
+	waitFor(t, func() bool { return fc.count("a") == 2 && fc.count("b") == 2 && fc.count("c") == 2 })
+}
+
+func TestBalancer_EjectAndProbe(t *testing.T) {
+	fc := newFakeCollectors()
+	b := newTestBalancer(t, fc, BalanceRoundRobin)
+
+	fc.kill("a")
+	for i := 0; i < 6; i++ {
+		if err := b.SendFrame(testFrame()); err != nil {
+			t.Fatalf("error to send frame: %s", err)
+		}
+	}
+	if len(b.Healthy()) != 2 {
+		t.Errorf("expected endpoint a ejected, healthy: %v", b.Healthy())
+	}
+	waitFor(t, func() bool { return fc.count("b")+fc.count("c") == 6 })
+
+	// re-added after a successful probe
+	fc.revive("a")
+	waitFor(t, func() bool { return len(b.Healthy()) == 3 })
+}
+
+func TestBalancer_NoEndpoint(t *testing.T) {
+	fc := newFakeCollectors()
+	fc.down["a"], fc.down["b"], fc.down["c"] = true, true, true
+	b := newTestBalancer(t, fc, BalanceRoundRobin)
+
+	if err := b.SendFrame(testFrame()); !errors.Is(err, ErrNoEndpointAvailable) {
+		t.Errorf("expected ErrNoEndpointAvailable, got %v", err)
+	}
+}
+
+func TestBalancer_LastEndpointError(t *testing.T) {
+	fc := newFakeCollectors()
+	b := newTestBalancer(t, fc, BalanceRoundRobin)
+
+	fc.kill("a")
+	fc.kill("b")
+	fc.kill("c")
+	err := b.SendFrame(testFrame())
+	var eperr *EndpointError
+	if !errors.As(err, &eperr) || !errors.Is(err, ErrNoEndpointAvailable) {
+		t.Fatalf("expected EndpointError, got %v", err)
+	}
+	if eperr.Address != "c" || eperr.Err == nil {
+		t.Errorf("expected the write error of c, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package framestream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+const checksumCRC32C = "crc32c"
+
+var ErrFrameChecksum = errors.New("frame checksum mismatch")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+/* Checksum error, matches ErrFrameChecksum */
+type ChecksumError struct {
+	Expected uint32
+	Computed uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s: expected %08x, computed %08x", ErrFrameChecksum, e.Expected, e.Computed)
+}
+
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrFrameChecksum
+}
+
+// SetChecksum offers (sender) or accepts (receiver) the checksum extension,
+// once negotiated each data frame carries a CRC32C trailer of its payload.
+// Without the handshake, the sender announces it in START and the receiver
+// always follows, so it must only be enabled towards receivers supporting it.
+func (fs *Fstrm) SetChecksum(enabled bool) {
+	fs.checksumEnabled = enabled
+}
+
+// ChecksumActive reports whether the checksum extension was negotiated
+func (fs *Fstrm) ChecksumActive() bool {
+	return fs.checksum
+}
+
+func offersChecksum(ctrl *ControlFrame) bool {
+	value, ok := ctrl.Field(CONTROL_FIELD_CHECKSUM)
+	return ok && bytes.Equal(value, []byte(checksumCRC32C))
+}
+
+// appendChecksum returns a copy of the data frame with the CRC32C trailer
+func appendChecksum(frame *Frame) *Frame {
+	payload := frame.data[4:]
+	data := make([]byte, len(frame.data)+4)
+	binary.BigEndian.PutUint32(data[:4], uint32(len(payload)+4))
+	copy(data[4:], payload)
+	binary.BigEndian.PutUint32(data[len(data)-4:], crc32.Checksum(payload, crc32cTable))
+	return &Frame{data: data, wire: true}
+}
+
+// verifyChecksum checks the trailer of a received payload and strips it
+func verifyChecksum(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, ErrFrameChecksum
+	}
+	payload := data[:len(data)-4]
+	expected := binary.BigEndian.Uint32(data[len(data)-4:])
+	if computed := crc32.Checksum(payload, crc32cTable); computed != expected {
+		return nil, &ChecksumError{Expected: expected, Computed: computed}
+	}
+	return payload, nil
+}
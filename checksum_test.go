@@ -0,0 +1,83 @@
+package framestream
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestChecksum_Negotiation(t *testing.T) {
+	testCases := []struct {
+		name     string
+		sender   bool
+		receiver bool
+		active   bool
+	}{
+		{name: "both", sender: true, receiver: true, active: true},
+		{name: "sender_only", sender: true, receiver: false, active: false},
+		{name: "receiver_only", sender: false, receiver: true, active: false},
+		{name: "none", sender: false, receiver: false, active: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sender, receiver := pipeSession(t,
+				func(fs *Fstrm) { fs.SetChecksum(tc.sender) },
+				func(fs *Fstrm) { fs.SetChecksum(tc.receiver) })
+			go sender.SendFrame(NewDataFrame([]byte{1, 2, 3, 4}))
+
+			frame, err := receiver.RecvFrame(true)
+			if err != nil {
+				t.Fatalf("error to receive frame: %s", err)
+			}
+			if !bytes.Equal(frame.Data(), []byte{1, 2, 3, 4}) {
+				t.Errorf("unexpected payload: %v", frame.Data())
+			}
+			if sender.ChecksumActive() != tc.active || receiver.ChecksumActive() != tc.active {
+				t.Errorf("expected checksum active=%v, sender=%v receiver=%v", tc.active, sender.ChecksumActive(), receiver.ChecksumActive())
+			}
+		})
+	}
+}
+
+func TestChecksum_Unidirectional(t *testing.T) {
+	for _, receiverEnabled := range []bool{true, false} {
+		sender, receiver := pipeSession(t,
+			func(fs *Fstrm) { fs.handshake = false; fs.SetChecksum(true) },
+			func(fs *Fstrm) { fs.handshake = false; fs.SetChecksum(receiverEnabled) })
+		go sender.SendFrame(NewDataFrame([]byte{1, 2, 3, 4}))
+
+		frame, err := receiver.RecvFrame(true)
+		if err != nil {
+			t.Fatalf("error to receive frame: %s", err)
+		}
+		if !bytes.Equal(frame.Data(), []byte{1, 2, 3, 4}) {
+			t.Errorf("unexpected payload: %v", frame.Data())
+		}
+		if !sender.ChecksumActive() || !receiver.ChecksumActive() {
+			t.Errorf("expected checksum announced in START, sender=%v receiver=%v", sender.ChecksumActive(), receiver.ChecksumActive())
+		}
+	}
+}
+
+func TestChecksum_Mismatch(t *testing.T) {
+	frame := appendChecksum(&Frame{data: []byte{0, 0, 0, 4, 1, 2, 3, 4}})
+	if !bytes.Equal(frame.data[:4], []byte{0, 0, 0, 8}) {
+		t.Fatalf("unexpected frame length: %v", frame.data[:4])
+	}
+
+	// flip a bit of the payload
+	frame.data[5] ^= 0x01
+	fs := NewFstrm(bufio.NewReader(bytes.NewReader(frame.data)), nil, nil, 0, []byte("ctype"), false)
+	fs.checksum = true
+
+	_, err := fs.RecvFrame(false)
+	if !errors.Is(err, ErrFrameChecksum) {
+		t.Fatalf("expected ErrFrameChecksum, got %v", err)
+	}
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) || checksumErr.Expected == checksumErr.Computed {
+		t.Errorf("expected a ChecksumError, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	framestream "github.com/dmachard/go-framestream"
+)
+
+func runDump(args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	input := flags.String("r", "", "capture file to read, - for stdin")
+	width := flags.Int("width", 32, "number of payload bytes printed in hex, 0 for the whole payload")
+	flags.Parse(args)
+
+	if *input == "" {
+		return fmt.Errorf("missing capture file (-r)")
+	}
+
+	r, err := openInput(*input)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return dump(os.Stdout, r, *width)
+}
+
+func dump(w io.Writer, r io.Reader, width int) error {
+	index := 0
+	return readCapture(r, func(frame *framestream.Frame) error {
+		index++
+		if frame.IsControl() {
+			ctrl, err := decodeControl(frame)
+			if err != nil {
+				fmt.Fprintf(w, "#%d %s\n", index, err)
+				return nil
+			}
+			fmt.Fprintf(w, "#%d %s\n", index, ctrl)
+			return nil
+		}
+
+		payload := frame.Payload()
+		truncated := ""
+		if width > 0 && len(payload) > width {
+			payload = payload[:width]
+			truncated = "..."
+		}
+		fmt.Fprintf(w, "#%d DATA len=%d %s%s\n", index, frame.Len(), hex.EncodeToString(payload), truncated)
+		return nil
+	})
+}
+
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(name)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	framestream "github.com/dmachard/go-framestream"
+)
+
+func runListen(args []string) error {
+	flags := flag.NewFlagSet("listen", flag.ExitOnError)
+	network := flags.String("network", "tcp", "network to listen on, tcp or unix")
+	address := flags.String("address", "127.0.0.1:6000", "address or socket path to listen on")
+	output := flags.String("w", "", "capture file to write, - for stdout")
+	ctype := flags.String("ctype", defaultContentType, "content type")
+	handshake := flags.Bool("handshake", true, "bidirectional handshake")
+	timeout := flags.Duration("timeout", 5*time.Second, "read timeout of control frames")
+	mode := flags.Uint("mode", 0, "file mode of the unix socket, for example 0660")
+	flags.Parse(args)
+
+	if *output == "" {
+		return fmt.Errorf("missing capture file (-w)")
+	}
+
+	var w io.WriteCloser = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	defer w.Close()
+
+	capture, err := newCaptureWriter(w, *ctype)
+	if err != nil {
+		return err
+	}
+
+	var listener net.Listener
+	if *network == "unix" {
+		listener, err = framestream.ListenUnix(*address, framestream.UnixListenerOptions{RemoveStale: true, Mode: os.FileMode(*mode)})
+	} else {
+		listener, err = net.Listen(*network, *address)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("listening on %s %s", *network, listener.Addr())
+
+	// stop on signal, closing the open sessions so the capture file is finalized
+	conns := newConnSet()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		listener.Close()
+		conns.close()
+	}()
+
+	serveListener(listener, conns, capture, *ctype, *handshake, *timeout)
+
+	log.Printf("%d frames captured", capture.frames)
+	return capture.close()
+}
+
+/* serve accepted connections until the listener is closed and all sessions returned */
+func serveListener(listener net.Listener, conns *connSet, capture *captureWriter, ctype string, handshake bool, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			break
+		}
+		if !conns.add(conn) {
+			conn.Close()
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conns.remove(conn)
+			if err := capture.serve(conn, ctype, handshake, timeout); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("%s: %s", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+/* set of open connections, closed all at once on stop */
+type connSet struct {
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func newConnSet() *connSet {
+	return &connSet{conns: make(map[net.Conn]struct{})}
+}
+
+// add returns false once the set is closed
+func (s *connSet) add(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *connSet) remove(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+	conn.Close()
+}
+
+func (s *connSet) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+/* capture file writer, shared by all connections */
+type captureWriter struct {
+	mu     sync.Mutex
+	fs     *framestream.Fstrm
+	frames int
+}
+
+func newCaptureWriter(w io.Writer, ctype string) (*captureWriter, error) {
+	fs := framestream.NewFstrm(nil, bufio.NewWriter(w), nil, 0, []byte(ctype), false)
+	if err := fs.InitSender(); err != nil {
+		return nil, err
+	}
+	return &captureWriter{fs: fs}, nil
+}
+
+func (c *captureWriter) write(payload []byte) error {
+	frame := &framestream.Frame{}
+	if err := frame.Write(payload); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frames++
+	return c.fs.SendFrame(frame)
+}
+
+func (c *captureWriter) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fs.ResetSender()
+}
+
+func (c *captureWriter) serve(conn net.Conn, ctype string, handshake bool, timeout time.Duration) error {
+	fs := framestream.NewFstrm(bufio.NewReader(conn), bufio.NewWriter(conn), conn, timeout, []byte(ctype), handshake)
+	if err := fs.InitReceiver(); err != nil {
+		return err
+	}
+
+	for {
+		frame, err := fs.RecvFrame(false)
+		if err != nil {
+			return err
+		}
+		if frame.IsControl() {
+			if err := fs.ResetReceiver(frame); err != io.EOF {
+				return err
+			}
+			return nil
+		}
+		if err := c.write(frame.Payload()); err != nil {
+			return err
+		}
+	}
+}
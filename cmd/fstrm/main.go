@@ -0,0 +1,80 @@
+// Command fstrm captures, dumps and replays Frame Streams.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	framestream "github.com/dmachard/go-framestream"
+)
+
+const defaultContentType = "protobuf:dnstap.Dnstap"
+
+const usage = `usage: fstrm <command> [options]
+
+commands:
+  listen   accept Frame Streams on tcp or unix and write them to a capture file
+  dump     print the frames of a capture file
+  send     replay a capture file to a collector
+  stats    report frame counts and size distribution of a capture file
+
+run "fstrm <command> -h" for the options of a command
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "listen":
+		err = runListen(os.Args[2:])
+	case "dump":
+		err = runDump(os.Args[2:])
+	case "send":
+		err = runSend(os.Args[2:])
+	case "stats":
+		err = runStats(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "fstrm: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fstrm %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// readCapture calls fn for every frame of a capture file
+func readCapture(r io.Reader, fn func(frame *framestream.Frame) error) error {
+	fs := framestream.NewFstrm(bufio.NewReader(r), nil, nil, 0, nil, false)
+	for {
+		frame, err := fs.RecvFrame(false)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeControl decodes the control frame of a capture file
+func decodeControl(frame *framestream.Frame) (*framestream.ControlFrame, error) {
+	ctrl := &framestream.ControlFrame{}
+	if err := ctrl.UnmarshalBinary(frame.Payload()); err != nil {
+		return nil, err
+	}
+	return ctrl, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	framestream "github.com/dmachard/go-framestream"
+	"github.com/dmachard/go-framestream/fstrmtest"
+)
+
+func captureFile(t *testing.T, payloads ...[]byte) []byte {
+	buf := new(bytes.Buffer)
+	capture, err := newCaptureWriter(buf, "ctype")
+	if err != nil {
+		t.Fatalf("error to create capture: %s", err)
+	}
+	for _, payload := range payloads {
+		if err := capture.write(payload); err != nil {
+			t.Fatalf("error to write capture: %s", err)
+		}
+	}
+	if err := capture.close(); err != nil {
+		t.Fatalf("error to close capture: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestListen_Serve(t *testing.T) {
+	local, remote := fstrmtest.Pipe(t)
+	wait := fstrmtest.Start(t, remote, fstrmtest.SenderScript("ctype", true, []byte{1, 2}, []byte{3}))
+
+	buf := new(bytes.Buffer)
+	capture, err := newCaptureWriter(buf, "ctype")
+	fstrmtest.AssertNoError(t, err)
+	fstrmtest.AssertNoError(t, capture.serve(local, "ctype", true, time.Second))
+	fstrmtest.AssertNoError(t, capture.close())
+	wait()
+
+	if !bytes.Equal(buf.Bytes(), captureFile(t, []byte{1, 2}, []byte{3})) {
+		t.Errorf("unexpected capture file: %x", buf.Bytes())
+	}
+}
+
+func TestListen_Stop(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	fstrmtest.AssertNoError(t, err)
+
+	buf := new(bytes.Buffer)
+	capture, err := newCaptureWriter(buf, "ctype")
+	fstrmtest.AssertNoError(t, err)
+
+	conns := newConnSet()
+	done := make(chan struct{})
+	go func() {
+		serveListener(listener, conns, capture, "ctype", true, time.Second)
+		close(done)
+	}()
+
+	// sender keeps its session open, the stop must not wait for it
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	fstrmtest.AssertNoError(t, err)
+	defer conn.Close()
+	fs := framestream.NewFstrm(bufio.NewReader(conn), bufio.NewWriter(conn), conn, time.Second, []byte("ctype"), true)
+	fstrmtest.AssertNoError(t, fs.InitSender())
+	frame := &framestream.Frame{}
+	fstrmtest.AssertNoError(t, frame.Write([]byte{1, 2}))
+	fstrmtest.AssertNoError(t, fs.SendFrame(frame))
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		capture.mu.Lock()
+		frames := capture.frames
+		capture.mu.Unlock()
+		if frames == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("frame not captured")
+		}
+	}
+
+	listener.Close()
+	conns.close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("sessions still open after stop")
+	}
+
+	fstrmtest.AssertNoError(t, capture.close())
+	if !bytes.Equal(buf.Bytes(), captureFile(t, []byte{1, 2})) {
+		t.Errorf("unexpected capture file: %x", buf.Bytes())
+	}
+}
+
+func TestDump(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := dump(out, bytes.NewReader(captureFile(t, []byte{1, 2, 3, 4}, []byte{5, 6})), 2)
+	fstrmtest.AssertNoError(t, err)
+
+	expected := `#1 START content-type=ctype
+#2 DATA len=4 0102...
+#3 DATA len=2 0506
+#4 STOP
+`
+	if out.String() != expected {
+		t.Errorf("unexpected dump:\n%s", out.String())
+	}
+}
+
+func TestStats(t *testing.T) {
+	st, err := collectStats(bytes.NewReader(captureFile(t, []byte{1}, []byte{1, 2, 3, 4}, []byte{1, 2, 3, 4, 5})))
+	fstrmtest.AssertNoError(t, err)
+
+	if st.frames != 3 || st.bytes != 10 || st.min != 1 || st.max != 5 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+	if st.controls["START"] != 1 || st.controls["STOP"] != 1 {
+		t.Errorf("unexpected control frames: %v", st.controls)
+	}
+
+	out := new(bytes.Buffer)
+	st.print(out)
+	if !strings.Contains(out.String(), "[4, 8) 2") {
+		t.Errorf("unexpected size distribution:\n%s", out.String())
+	}
+}
+
+func TestSend(t *testing.T) {
+	local, remote := fstrmtest.Pipe(t)
+	wait := fstrmtest.Start(t, remote, fstrmtest.ReceiverScript("ctype", true, []byte{1, 2}, []byte{3}))
+
+	start := time.Now()
+	sent, err := send(local, bytes.NewReader(captureFile(t, []byte{1, 2}, []byte{3})), sendOptions{handshake: true, rate: 20, timeout: time.Second})
+	fstrmtest.AssertNoError(t, err)
+	wait()
+
+	if sent != 2 {
+		t.Errorf("expected 2 frames sent, got %d", sent)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("rate not applied")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	framestream "github.com/dmachard/go-framestream"
+)
+
+type sendOptions struct {
+	ctype     string
+	handshake bool
+	rate      float64
+	timeout   time.Duration
+}
+
+func runSend(args []string) error {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	input := flags.String("r", "", "capture file to replay, - for stdin")
+	network := flags.String("network", "tcp", "network of the collector, tcp or unix")
+	address := flags.String("address", "127.0.0.1:6000", "address or socket path of the collector")
+	ctype := flags.String("ctype", "", "content type, taken from the capture file by default")
+	handshake := flags.Bool("handshake", true, "bidirectional handshake")
+	rate := flags.Float64("rate", 0, "maximum data frames per second, 0 for no limit")
+	timeout := flags.Duration("timeout", 5*time.Second, "read timeout of control frames")
+	flags.Parse(args)
+
+	if *input == "" {
+		return fmt.Errorf("missing capture file (-r)")
+	}
+
+	r, err := openInput(*input)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	conn, err := net.DialTimeout(*network, *address, *timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sent, err := send(conn, r, sendOptions{ctype: *ctype, handshake: *handshake, rate: *rate, timeout: *timeout})
+	log.Printf("%d frames sent", sent)
+	return err
+}
+
+// send replays the data frames of a capture file on conn
+func send(conn net.Conn, r io.Reader, opts sendOptions) (int, error) {
+	var fs *framestream.Fstrm
+	start := func(ctype string) error {
+		if ctype == "" {
+			ctype = defaultContentType
+		}
+		fs = framestream.NewFstrm(bufio.NewReader(conn), bufio.NewWriter(conn), conn, opts.timeout, []byte(ctype), opts.handshake)
+		if opts.rate > 0 {
+			// evenly spaced frames, no burst
+			burst := time.Duration(float64(time.Second) / opts.rate)
+			fs.SetSendRateLimit(framestream.RateLimit{FramesPerSecond: opts.rate, Burst: burst})
+		}
+		return fs.InitSender()
+	}
+
+	sent := 0
+	err := readCapture(r, func(frame *framestream.Frame) error {
+		if frame.IsControl() {
+			// start the session with the content type of the capture file
+			if fs != nil {
+				return nil
+			}
+			ctrl, err := decodeControl(frame)
+			if err != nil || ctrl.Type() != framestream.CONTROL_START {
+				return nil
+			}
+			ctype := opts.ctype
+			if ctype == "" && len(ctrl.ContentTypes()) > 0 {
+				ctype = string(ctrl.ContentTypes()[0])
+			}
+			return start(ctype)
+		}
+
+		if fs == nil {
+			if err := start(opts.ctype); err != nil {
+				return err
+			}
+		}
+
+		if err := fs.SendFrame(frame); err != nil {
+			return err
+		}
+		sent++
+		return nil
+	})
+	if err != nil {
+		return sent, err
+	}
+
+	if fs != nil {
+		err = fs.ResetSender()
+	}
+	return sent, err
+}
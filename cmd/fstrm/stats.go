@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"sort"
+
+	framestream "github.com/dmachard/go-framestream"
+)
+
+func runStats(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	input := flags.String("r", "", "capture file to read, - for stdin")
+	flags.Parse(args)
+
+	if *input == "" {
+		return fmt.Errorf("missing capture file (-r)")
+	}
+
+	r, err := openInput(*input)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	st, err := collectStats(r)
+	if err != nil {
+		return err
+	}
+	st.print(os.Stdout)
+	return nil
+}
+
+type stats struct {
+	controls map[string]int
+	frames   int
+	bytes    int
+	min      int
+	max      int
+	// data frames count per size bucket, bucket n holds sizes in [2^(n-1), 2^n)
+	buckets map[int]int
+}
+
+func collectStats(r io.Reader) (*stats, error) {
+	st := &stats{controls: make(map[string]int), buckets: make(map[int]int)}
+	err := readCapture(r, func(frame *framestream.Frame) error {
+		if frame.IsControl() {
+			name := "MALFORMED"
+			if ctrl, err := decodeControl(frame); err == nil {
+				name = framestream.ControlTypeName(ctrl.Type())
+			}
+			st.controls[name]++
+			return nil
+		}
+
+		size := frame.Len()
+		if st.frames == 0 || size < st.min {
+			st.min = size
+		}
+		if size > st.max {
+			st.max = size
+		}
+		st.frames++
+		st.bytes += size
+		st.buckets[bits.Len(uint(size))]++
+		return nil
+	})
+	return st, err
+}
+
+func (st *stats) print(w io.Writer) {
+	names := make([]string, 0, len(st.controls))
+	for name := range st.controls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "control %-8s %d\n", name, st.controls[name])
+	}
+
+	fmt.Fprintf(w, "data frames      %d\n", st.frames)
+	fmt.Fprintf(w, "data bytes       %d\n", st.bytes)
+	if st.frames == 0 {
+		return
+	}
+	fmt.Fprintf(w, "size min/avg/max %d/%d/%d\n", st.min, st.bytes/st.frames, st.max)
+
+	buckets := make([]int, 0, len(st.buckets))
+	for bucket := range st.buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+	fmt.Fprintln(w, "size distribution")
+	for _, bucket := range buckets {
+		low, high := 0, 1
+		if bucket > 0 {
+			low, high = 1<<(bucket-1), 1<<bucket
+		}
+		fmt.Fprintf(w, "  [%d, %d) %d\n", low, high, st.buckets[bucket])
+	}
+}
@@ -0,0 +1,112 @@
+package framestream
+
+import (
+	"errors"
+)
+
+/* Conformance mode */
+type Conformance int
+
+const (
+	// ConformanceDefault keeps the historical behavior: only the checks
+	// needed to run the handshake are applied.
+	ConformanceDefault Conformance = iota
+	// ConformanceStrict validates every control frame against the Frame Streams spec.
+	ConformanceStrict
+	// ConformanceLenient tolerates known vendor quirks and reports them as warnings.
+	ConformanceLenient
+)
+
+var ErrControlFrameLengthMismatch = errors.New("control frame length mismatch")
+var ErrControlFrameTooManyContentTypes = errors.New("control frame with too many content types")
+var ErrControlFrameUnexpectedFields = errors.New("control frame with unexpected fields")
+var ErrControlFrameContentTypeMissing = errors.New("control frame without content type")
+
+func (c Conformance) String() string {
+	switch c {
+	case ConformanceStrict:
+		return "strict"
+	case ConformanceLenient:
+		return "lenient"
+	default:
+		return "default"
+	}
+}
+
+// Validate checks a decoded control frame against the Frame Streams spec
+// and returns the first violation found.
+func (ctrl *ControlFrame) Validate() error {
+	// the encoded length must match the frame content
+	if len(ctrl.data) >= 4 && int(ctrl.cflen) != len(ctrl.data)-4 {
+		return ErrControlFrameLengthMismatch
+	}
+
+	switch ctrl.ctype {
+	case CONTROL_ACCEPT, CONTROL_START:
+		// at most one content type
+		if len(ctrl.ctypes) > 1 {
+			return ErrControlFrameTooManyContentTypes
+		}
+	case CONTROL_STOP, CONTROL_FINISH:
+		// no field allowed
+		if len(ctrl.ctypes) > 0 || len(ctrl.fields) > 0 {
+			return ErrControlFrameUnexpectedFields
+		}
+	case CONTROL_ACK:
+		// sequence number only
+		if len(ctrl.ctypes) > 0 {
+			return ErrControlFrameUnexpectedFields
+		}
+	case CONTROL_READY:
+		// any number of content types
+	default:
+		return ErrControlFrameUnsupported
+	}
+	return nil
+}
+
+func (fs *Fstrm) SetConformance(mode Conformance) {
+	fs.conformance = mode
+}
+
+// SetWarningHandler registers the callback used in lenient mode
+// to report every tolerated deviation from the spec.
+func (fs *Fstrm) SetWarningHandler(handler func(err error)) {
+	fs.warningHandler = handler
+}
+
+func (fs *Fstrm) warn(err error) {
+	if fs.warningHandler != nil {
+		fs.warningHandler(err)
+	}
+}
+
+// conform applies the conformance mode to a decoded control frame
+func (fs *Fstrm) conform(ctrl *ControlFrame) error {
+	if fs.conformance == ConformanceDefault {
+		return nil
+	}
+
+	err := ctrl.Validate()
+	if err == nil {
+		return nil
+	}
+	if fs.conformance == ConformanceLenient {
+		fs.warn(err)
+		return nil
+	}
+	return err
+}
+
+// checkStartContentType checks the content type of the START control frame,
+// a missing content type is tolerated in lenient mode
+func (fs *Fstrm) checkStartContentType(ctrl *ControlFrame) error {
+	if ctrl.CheckContentType(fs.ctype) {
+		return nil
+	}
+	if fs.conformance == ConformanceLenient && len(ctrl.ctypes) == 0 {
+		fs.warn(ErrControlFrameContentTypeMissing)
+		return nil
+	}
+	return ErrControlFrameContentTypeUnsupported
+}
@@ -0,0 +1,172 @@
+package framestream
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestControlValidate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		ctrl  *ControlFrame
+		error error
+	}{
+		{
+			name: "ready_many_content_types",
+			ctrl: &ControlFrame{ctype: CONTROL_READY, ctypes: [][]byte{[]byte("a"), []byte("b")}},
+		},
+		{
+			name:  "accept_many_content_types",
+			ctrl:  &ControlFrame{ctype: CONTROL_ACCEPT, ctypes: [][]byte{[]byte("a"), []byte("b")}},
+			error: ErrControlFrameTooManyContentTypes,
+		},
+		{
+			name:  "start_many_content_types",
+			ctrl:  &ControlFrame{ctype: CONTROL_START, ctypes: [][]byte{[]byte("a"), []byte("b")}},
+			error: ErrControlFrameTooManyContentTypes,
+		},
+		{
+			name:  "stop_with_content_type",
+			ctrl:  &ControlFrame{ctype: CONTROL_STOP, ctypes: [][]byte{[]byte("a")}},
+			error: ErrControlFrameUnexpectedFields,
+		},
+		{
+			name:  "finish_with_content_type",
+			ctrl:  &ControlFrame{ctype: CONTROL_FINISH, ctypes: [][]byte{[]byte("a")}},
+			error: ErrControlFrameUnexpectedFields,
+		},
+		{
+			name: "finish",
+			ctrl: &ControlFrame{ctype: CONTROL_FINISH},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.ctrl.Encode(); err != nil {
+				t.Fatalf("error to encode control frame: %s", err)
+			}
+			if err := tc.ctrl.Validate(); !errors.Is(err, tc.error) {
+				t.Errorf("expected %v, got %v", tc.error, err)
+			}
+		})
+	}
+
+	// length mismatch
+	ctrl := &ControlFrame{data: []byte{0, 0, 0, 8, 0, 0, 0, 3}}
+	if err := ctrl.Decode(); err != nil {
+		t.Fatalf("error to decode control frame: %s", err)
+	}
+	if err := ctrl.Validate(); !errors.Is(err, ErrControlFrameLengthMismatch) {
+		t.Errorf("expected ErrControlFrameLengthMismatch, got %v", err)
+	}
+}
+
+func stopFrameWithContentType(t *testing.T) *Frame {
+	ctrl := &ControlFrame{ctype: CONTROL_STOP, ctypes: [][]byte{[]byte("ctype")}}
+	if err := ctrl.Encode(); err != nil {
+		t.Fatalf("error to encode control frame: %s", err)
+	}
+	return &Frame{data: ctrl.data, control: true}
+}
+
+func TestResetReceiver_Conformance(t *testing.T) {
+	frame := stopFrameWithContentType(t)
+
+	// default mode ignores the content type
+	fs := NewFstrm(nil, nil, nil, 0, []byte("ctype"), false)
+	if err := fs.ResetReceiver(frame); !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF in default mode, got %v", err)
+	}
+
+	// strict mode rejects it
+	fs.SetConformance(ConformanceStrict)
+	if err := fs.ResetReceiver(frame); !errors.Is(err, ErrControlFrameUnexpectedFields) {
+		t.Errorf("expected ErrControlFrameUnexpectedFields in strict mode, got %v", err)
+	}
+
+	// lenient mode accepts it and reports a warning
+	var warnings []error
+	fs.SetConformance(ConformanceLenient)
+	fs.SetWarningHandler(func(err error) { warnings = append(warnings, err) })
+	if err := fs.ResetReceiver(frame); !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF in lenient mode, got %v", err)
+	}
+	if len(warnings) != 1 || !errors.Is(warnings[0], ErrControlFrameUnexpectedFields) {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+}
+
+func TestResetReceiver_LenientUnknownControl(t *testing.T) {
+	frame := &Frame{data: []byte{0, 0, 0, 4, 0, 0, 0, 8}, control: true}
+
+	fs := NewFstrm(nil, nil, nil, 0, []byte("ctype"), false)
+	if err := fs.ResetReceiver(frame); !errors.Is(err, ErrControlFrameUnsupported) {
+		t.Errorf("expected ErrControlFrameUnsupported, got %v", err)
+	}
+
+	warned := 0
+	fs.SetConformance(ConformanceLenient)
+	fs.SetWarningHandler(func(err error) { warned++ })
+	if err := fs.ResetReceiver(frame); err != nil {
+		t.Errorf("expected unknown control frame to be skipped, got %v", err)
+	}
+	if warned != 1 {
+		t.Errorf("expected one warning, got %d", warned)
+	}
+}
+
+func TestFramestream_LenientStartWithoutContentType(t *testing.T) {
+	for _, mode := range []Conformance{ConformanceDefault, ConformanceStrict, ConformanceLenient} {
+		t.Run(mode.String(), func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			// sender without content type
+			go func() {
+				fs_server := NewFstrm(bufio.NewReader(server), bufio.NewWriter(server), server, 5*time.Second, nil, false)
+				fs_server.SendControl(&ControlFrame{ctype: CONTROL_START})
+			}()
+
+			warned := 0
+			fs_client := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 5*time.Second, []byte("ctype"), false)
+			fs_client.SetConformance(mode)
+			fs_client.SetWarningHandler(func(err error) { warned++ })
+
+			err := fs_client.InitReceiver()
+			if mode == ConformanceLenient {
+				if err != nil || warned != 1 {
+					t.Errorf("expected START to be tolerated with a warning, got %v (%d warnings)", err, warned)
+				}
+			} else if !errors.Is(err, ErrControlFrameContentTypeUnsupported) {
+				t.Errorf("expected ErrControlFrameContentTypeUnsupported, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFramestream_StrictHandshake(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// accept frame with two content types
+	go func() {
+		fs_server := NewFstrm(bufio.NewReader(server), bufio.NewWriter(server), server, 5*time.Second, []byte("ctype"), true)
+		if _, err := fs_server.RecvControl(); err != nil {
+			return
+		}
+		fs_server.SendControl(&ControlFrame{ctype: CONTROL_ACCEPT, ctypes: [][]byte{[]byte("ctype"), []byte("other")}})
+	}()
+
+	fs_client := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 5*time.Second, []byte("ctype"), true)
+	fs_client.SetConformance(ConformanceStrict)
+	if err := fs_client.InitSender(); !errors.Is(err, ErrControlFrameTooManyContentTypes) {
+		t.Errorf("expected ErrControlFrameTooManyContentTypes, got %v", err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"strings"
 )
 
 const CONTROL_ACCEPT = 0x01
@@ -12,10 +14,29 @@ const CONTROL_STOP = 0x03
 const CONTROL_READY = 0x04
 const CONTROL_FINISH = 0x05
 
+// acknowledgement of data frames, sent by the receiver once negotiated
+const CONTROL_ACK = 0x06
+
 const CONTROL_FIELD_CONTENT_TYPE = 0x01
 
+// extension fields, negotiated in READY/ACCEPT
+const CONTROL_FIELD_CHECKSUM = 0x8001
+const CONTROL_FIELD_MAX_FRAME_SIZE = 0x8002
+const CONTROL_FIELD_FRAGMENTATION = 0x8003
+const CONTROL_FIELD_ACKNOWLEDGE = 0x8004
+const CONTROL_FIELD_SEQUENCE = 0x8005
+const CONTROL_FIELD_SEQUENCING = 0x8006
+const CONTROL_FIELD_AUTH_KEY_ID = 0x8007
+const CONTROL_FIELD_AUTH_NONCE = 0x8008
+const CONTROL_FIELD_AUTH_HMAC = 0x8009
+
 const DefaultControlFrameMaxLength = 4064
 
+// prefix of the content type carrying the extension fields offered in READY
+const EXTENSION_CONTENT_TYPE_PREFIX = "x-fstrm-extensions:"
+
+var extensionContentType = []byte(EXTENSION_CONTENT_TYPE_PREFIX)
+
 var ErrControlFrameTooLarge = errors.New("control frame too large error")
 var ErrControlFrameMalformed = errors.New("control frame malformed")
 var ErrControlFrameExpected = errors.New("control frame expected")
@@ -36,15 +57,64 @@ Control Frame struct
 |------------------------------------|----------------------|
 | Content type payload               | xx bytes             |
 |------------------------------------|----------------------|
+
+Extension fields use the same layout as the content type field. In READY,
+they are packed in an extra content type starting with extensionContentType,
+that receivers without extension support ignore when they choose the content
+type to accept.
 */
 type ControlFrame struct {
 	data      []byte
 	cflen     uint32
 	ctype     uint32
 	ctypes    [][]byte
+	fields    []ControlField
 	maxLength uint32
 }
 
+/* Control frame field, other than content type */
+type ControlField struct {
+	Type  uint32
+	Value []byte
+}
+
+// NewControlFrame builds an encoded control frame, ready for SendControl
+func NewControlFrame(ctype uint32, contentTypes ...[]byte) *ControlFrame {
+	ctrl := &ControlFrame{ctype: ctype, ctypes: contentTypes}
+	ctrl.Encode()
+	return ctrl
+}
+
+// ControlTypeName returns the name of a control frame type, for display
+func ControlTypeName(ctype uint32) string {
+	switch ctype {
+	case CONTROL_ACCEPT:
+		return "ACCEPT"
+	case CONTROL_START:
+		return "START"
+	case CONTROL_STOP:
+		return "STOP"
+	case CONTROL_READY:
+		return "READY"
+	case CONTROL_FINISH:
+		return "FINISH"
+	case CONTROL_ACK:
+		return "ACK"
+	default:
+		return fmt.Sprintf("CONTROL(%d)", ctype)
+	}
+}
+
+func isExtensionField(ftype uint32) bool {
+	switch ftype {
+	case CONTROL_FIELD_CHECKSUM, CONTROL_FIELD_MAX_FRAME_SIZE, CONTROL_FIELD_FRAGMENTATION,
+		CONTROL_FIELD_ACKNOWLEDGE, CONTROL_FIELD_SEQUENCE, CONTROL_FIELD_SEQUENCING,
+		CONTROL_FIELD_AUTH_KEY_ID, CONTROL_FIELD_AUTH_NONCE, CONTROL_FIELD_AUTH_HMAC:
+		return true
+	}
+	return false
+}
+
 func (ctrl *ControlFrame) Decode() error {
 	// checking if data is enough
 	if len(ctrl.data) < 8 {
@@ -63,7 +133,7 @@ func (ctrl *ControlFrame) Decode() error {
 
 	// decoding content type
 	ctrl.ctype = binary.BigEndian.Uint32(ctrl.data[4:8])
-	if ctrl.ctype > CONTROL_FINISH {
+	if ctrl.ctype > CONTROL_ACK {
 		return ErrControlFrameUnsupported
 	}
 
@@ -72,7 +142,7 @@ func (ctrl *ControlFrame) Decode() error {
 		cfields := ctrl.data[8:]
 		for len(cfields) >= 8 {
 			cf_ctype := binary.BigEndian.Uint32(cfields[:4])
-			if cf_ctype != CONTROL_FIELD_CONTENT_TYPE {
+			if cf_ctype != CONTROL_FIELD_CONTENT_TYPE && !isExtensionField(cf_ctype) {
 				return ErrControlFrameMalformed
 			}
 			cf_clen := binary.BigEndian.Uint32(cfields[4:8])
@@ -80,7 +150,18 @@ func (ctrl *ControlFrame) Decode() error {
 				return ErrControlFrameMalformed
 			}
 
-			ctrl.ctypes = append(ctrl.ctypes, cfields[8:cf_clen+8])
+			value := cfields[8 : cf_clen+8]
+			if cf_ctype == CONTROL_FIELD_CONTENT_TYPE && bytes.HasPrefix(value, extensionContentType) {
+				fields, err := unpackFields(value[len(extensionContentType):])
+				if err != nil {
+					return err
+				}
+				ctrl.fields = append(ctrl.fields, fields...)
+			} else if cf_ctype == CONTROL_FIELD_CONTENT_TYPE {
+				ctrl.ctypes = append(ctrl.ctypes, value)
+			} else {
+				ctrl.fields = append(ctrl.fields, ControlField{Type: cf_ctype, Value: cfields[8 : cf_clen+8]})
+			}
 			cfields = cfields[cf_clen+8:]
 		}
 
@@ -92,12 +173,62 @@ func (ctrl *ControlFrame) Decode() error {
 	return nil
 }
 
+// unpackFields decodes the extension fields packed in a content type,
+// fields unknown to this version are skipped so that peers can add new ones
+func unpackFields(data []byte) ([]ControlField, error) {
+	var fields []ControlField
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, ErrControlFrameMalformed
+		}
+		ftype := binary.BigEndian.Uint32(data[:4])
+		flen := binary.BigEndian.Uint32(data[4:8])
+		if uint32(len(data)-8) < flen {
+			return nil, ErrControlFrameMalformed
+		}
+		if isExtensionField(ftype) {
+			fields = append(fields, ControlField{Type: ftype, Value: data[8 : 8+flen]})
+		}
+		data = data[8+flen:]
+	}
+	return fields, nil
+}
+
+// packFields returns the content types of READY followed by one holding the
+// extension fields, since the receiver may not support them
+func (ctrl *ControlFrame) packFields() [][]byte {
+	size := len(extensionContentType)
+	for _, field := range ctrl.fields {
+		size += 8 + len(field.Value)
+	}
+	packed := make([]byte, 0, size)
+	packed = append(packed, extensionContentType...)
+	for _, field := range ctrl.fields {
+		packed = binary.BigEndian.AppendUint32(packed, field.Type)
+		packed = binary.BigEndian.AppendUint32(packed, uint32(len(field.Value)))
+		packed = append(packed, field.Value...)
+	}
+
+	ctypes := make([][]byte, len(ctrl.ctypes), len(ctrl.ctypes)+1)
+	copy(ctypes, ctrl.ctypes)
+	return append(ctypes, packed)
+}
+
 func (ctrl *ControlFrame) Encode() error {
+	// other control frames are only sent to peers supporting the extensions
+	ctypes, fields := ctrl.ctypes, ctrl.fields
+	if ctrl.ctype == CONTROL_READY && len(fields) > 0 {
+		ctypes, fields = ctrl.packFields(), nil
+	}
+
 	// compute the control frame length
-	cflen := 4 + len(ctrl.ctypes)*8
-	for _, ctype := range ctrl.ctypes {
+	cflen := 4 + len(ctypes)*8 + len(fields)*8
+	for _, ctype := range ctypes {
 		cflen += len(ctype)
 	}
+	for _, field := range fields {
+		cflen += len(field.Value)
+	}
 	ctrl.cflen = uint32(cflen)
 
 	// allocate exact buffer: 4 bytes for length + length of content
@@ -113,7 +244,7 @@ func (ctrl *ControlFrame) Encode() error {
 	offset := 8
 
 	// add optional fields
-	for _, ctype := range ctrl.ctypes {
+	for _, ctype := range ctypes {
 		// content type (4 bytes)
 		binary.BigEndian.PutUint32(ctrl.data[offset:offset+4], uint32(CONTROL_FIELD_CONTENT_TYPE))
 		offset += 4
@@ -127,6 +258,15 @@ func (ctrl *ControlFrame) Encode() error {
 		offset += len(ctype)
 	}
 
+	// add extension fields
+	for _, field := range fields {
+		binary.BigEndian.PutUint32(ctrl.data[offset:offset+4], field.Type)
+		binary.BigEndian.PutUint32(ctrl.data[offset+4:offset+8], uint32(len(field.Value)))
+		offset += 8
+		copy(ctrl.data[offset:], field.Value)
+		offset += len(field.Value)
+	}
+
 	return nil
 }
 
@@ -138,3 +278,70 @@ func (ctrl *ControlFrame) CheckContentType(ctype []byte) bool {
 	}
 	return false
 }
+
+// Field returns the value of the first extension field of this type
+func (ctrl *ControlFrame) Field(ftype uint32) ([]byte, bool) {
+	for _, field := range ctrl.fields {
+		if field.Type == ftype {
+			return field.Value, true
+		}
+	}
+	return nil, false
+}
+
+// AddField appends an extension field and encodes the frame again
+func (ctrl *ControlFrame) AddField(ftype uint32, value []byte) {
+	ctrl.fields = append(ctrl.fields, ControlField{Type: ftype, Value: value})
+	ctrl.Encode()
+}
+
+func (ctrl *ControlFrame) Type() uint32 {
+	return ctrl.ctype
+}
+
+func (ctrl *ControlFrame) ContentTypes() [][]byte {
+	return ctrl.ctypes
+}
+
+// Fields returns the extension fields, content types excluded
+func (ctrl *ControlFrame) Fields() []ControlField {
+	return ctrl.fields
+}
+
+// Bytes returns the encoded control frame, starting with its length
+// and without the escape sequence of the frame
+func (ctrl *ControlFrame) Bytes() []byte {
+	if ctrl.data == nil {
+		ctrl.Encode()
+	}
+	return ctrl.data
+}
+
+func (ctrl *ControlFrame) String() string {
+	var b strings.Builder
+	b.WriteString(ControlTypeName(ctrl.ctype))
+	if len(ctrl.ctypes) > 0 {
+		ctypes := make([]string, len(ctrl.ctypes))
+		for i, ctype := range ctrl.ctypes {
+			ctypes[i] = string(ctype)
+		}
+		fmt.Fprintf(&b, " content-type=%s", strings.Join(ctypes, ","))
+	}
+	for _, field := range ctrl.fields {
+		fmt.Fprintf(&b, " field(%#x)=%q", field.Type, field.Value)
+	}
+	return b.String()
+}
+
+func (ctrl *ControlFrame) MarshalBinary() ([]byte, error) {
+	if err := ctrl.Encode(); err != nil {
+		return nil, err
+	}
+	return append([]byte{}, ctrl.data...), nil
+}
+
+// UnmarshalBinary decodes a control frame in the format returned by Bytes
+func (ctrl *ControlFrame) UnmarshalBinary(data []byte) error {
+	*ctrl = ControlFrame{data: append([]byte{}, data...), maxLength: ctrl.maxLength}
+	return ctrl.Decode()
+}
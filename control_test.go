@@ -1,6 +1,7 @@
 package framestream
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -145,3 +146,67 @@ func TestControlDecode_EmptyOptionalField(t *testing.T) {
 		t.Errorf("failed to decode control frame with empty optional field: %v", err)
 	}
 }
+
+func TestControlEncodeDecode_ExtensionFields(t *testing.T) {
+	ctrl := &ControlFrame{
+		ctype:  CONTROL_READY,
+		ctypes: [][]byte{[]byte("protobuf:dnstap.Dnstap")},
+		fields: []ControlField{{Type: CONTROL_FIELD_CHECKSUM, Value: []byte("crc32c")}},
+	}
+	if err := ctrl.Encode(); err != nil {
+		t.Fatalf("error to encode control frame %s", err)
+	}
+
+	decoded := &ControlFrame{data: ctrl.data}
+	if err := decoded.Decode(); err != nil {
+		t.Fatalf("error to decode control frame %s", err)
+	}
+	if !decoded.CheckContentType([]byte("protobuf:dnstap.Dnstap")) {
+		t.Errorf("content type not decoded")
+	}
+	if value, ok := decoded.Field(CONTROL_FIELD_CHECKSUM); !ok || string(value) != "crc32c" {
+		t.Errorf("extension field not decoded")
+	}
+
+	// unknown field type
+	unknown := []byte{0, 0, 0, 12, 0, 0, 0, 4, 0, 0, 0x12, 0x34, 0, 0, 0, 0}
+	if err := (&ControlFrame{data: unknown}).Decode(); err != ErrControlFrameMalformed {
+		t.Errorf("expected ErrControlFrameMalformed, got %v", err)
+	}
+}
+
+func TestControlFrame_PublicAPI(t *testing.T) {
+	ctrl := NewControlFrame(CONTROL_ACCEPT, []byte("protobuf:dnstap.Dnstap"))
+	if !bytes.Equal(ctrl.Bytes(), ctrl_frame_accept) {
+		t.Errorf("unexpected encoding: %v", ctrl.Bytes())
+	}
+
+	ctrl = NewControlFrame(CONTROL_READY, []byte("a"), []byte("b"))
+	ctrl.AddField(CONTROL_FIELD_CHECKSUM, []byte("crc32c"))
+	if got := ctrl.String(); got != `READY content-type=a,b field(0x8001)="crc32c"` {
+		t.Errorf("unexpected string: %s", got)
+	}
+
+	data, err := ctrl.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error to marshal control frame: %s", err)
+	}
+	decoded := &ControlFrame{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("error to unmarshal control frame: %s", err)
+	}
+	if decoded.Type() != CONTROL_READY || len(decoded.ContentTypes()) != 2 || len(decoded.Fields()) != 1 {
+		t.Errorf("unexpected decoded frame: %s", decoded)
+	}
+	if err := decoded.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %s", err)
+	}
+
+	// decoding again does not accumulate fields
+	if err := decoded.UnmarshalBinary(data); err != nil || len(decoded.ContentTypes()) != 2 {
+		t.Errorf("unexpected second decoding: %v %s", err, decoded)
+	}
+	if err := decoded.UnmarshalBinary([]byte{0, 0, 0, 4}); err != ErrControlFrameMalformed {
+		t.Errorf("expected ErrControlFrameMalformed, got %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package framestream
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+type decoderState int
+
+const (
+	decoderReady decoderState = iota
+	decoderStart
+	decoderData
+)
+
+/*
+Push parser
+
+The decoder is fed with byte chunks as they arrive, for event-driven servers
+that cannot block on a reader. It runs the receiver side of the session:
+the handshake control frames are answered through the bytes returned by Feed,
+and every complete frame is passed to the handler.
+*/
+type Decoder struct {
+	ctype                 []byte
+	handshake             bool
+	dataFrameMaxLength    uint32
+	controlFrameMaxLength uint32
+	handler               func(frame *Frame) error
+	state                 decoderState
+	// partial frame kept between calls
+	buf []byte
+	err error
+}
+
+// NewDecoder creates a receiver decoder, the handler is called
+// for every complete data and control frame
+func NewDecoder(ctype []byte, handshake bool, handler func(frame *Frame) error) *Decoder {
+	d := &Decoder{
+		ctype:                 ctype,
+		handshake:             handshake,
+		dataFrameMaxLength:    DefaultDataFrameMaxLength,
+		controlFrameMaxLength: DefaultControlFrameMaxLength,
+		handler:               handler,
+		state:                 decoderStart,
+	}
+	if handshake {
+		d.state = decoderReady
+	}
+	return d
+}
+
+func (d *Decoder) SetDataFrameMaxLength(length uint32) {
+	d.dataFrameMaxLength = length
+}
+
+func (d *Decoder) SetControlFrameMaxLength(length uint32) {
+	d.controlFrameMaxLength = length
+}
+
+// Feed consumes a chunk of bytes and returns the bytes to write back to the
+// sender. It returns io.EOF once the STOP control frame is decoded, the first
+// error is returned again by the next calls.
+func (d *Decoder) Feed(chunk []byte) (out []byte, err error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	// parse from the chunk directly when nothing is pending
+	data := chunk
+	if len(d.buf) > 0 {
+		d.buf = append(d.buf, chunk...)
+		data = d.buf
+	}
+
+	offset := 0
+	for d.err == nil {
+		n, frame, err := d.next(data[offset:])
+		if err != nil {
+			d.err = err
+			break
+		}
+		if frame == nil {
+			break
+		}
+		offset += n
+		out, d.err = d.process(frame, out)
+	}
+
+	// keep the partial frame
+	d.buf = append(d.buf[:0], data[offset:]...)
+	return out, d.err
+}
+
+// next decodes the frame at the start of data, it returns a nil
+// frame when more bytes are needed
+func (d *Decoder) next(data []byte) (int, *Frame, error) {
+	if len(data) < 4 {
+		return 0, nil, nil
+	}
+	length := binary.BigEndian.Uint32(data[:4])
+	offset := 4
+	maxLength := d.dataFrameMaxLength
+	if maxLength == 0 {
+		maxLength = DefaultDataFrameMaxLength
+	}
+
+	// control frame, the control length follows the escape
+	isControl := length == 0
+	if isControl {
+		if len(data) < 8 {
+			return 0, nil, nil
+		}
+		length = binary.BigEndian.Uint32(data[4:8])
+		maxLength = d.controlFrameMaxLength
+		if maxLength == 0 {
+			maxLength = DefaultControlFrameMaxLength
+		}
+	}
+
+	// same bounds as readFrame
+	total := int(length)
+	if isControl {
+		total += 4
+	}
+	if total > int(maxLength) {
+		return 0, nil, ErrFrameTooLarge
+	}
+	if len(data) < offset+total {
+		return 0, nil, nil
+	}
+
+	frame := &Frame{data: make([]byte, total), control: isControl}
+	copy(frame.data, data[offset:offset+total])
+	return offset + total, frame, nil
+}
+
+// process runs the handshake state machine and appends the answer to out
+func (d *Decoder) process(frame *Frame, out []byte) ([]byte, error) {
+	var ctrl *ControlFrame
+	if frame.control {
+		ctrl = &ControlFrame{data: frame.data, maxLength: d.controlFrameMaxLength}
+		if err := ctrl.Decode(); err != nil {
+			return out, err
+		}
+	} else if d.state != decoderData {
+		return out, ErrControlFrameExpected
+	}
+
+	if err := d.handler(frame); err != nil {
+		return out, err
+	}
+	if ctrl == nil {
+		return out, nil
+	}
+
+	switch d.state {
+	case decoderReady:
+		if ctrl.ctype != CONTROL_READY {
+			return out, ErrControlFrameUnexpected
+		}
+		if !ctrl.CheckContentType(d.ctype) {
+			return out, ErrControlFrameContentTypeUnsupported
+		}
+		d.state = decoderStart
+		return append(out, NewControlFrameFrame(NewControlFrame(CONTROL_ACCEPT, d.ctype)).WireBytes()...), nil
+
+	case decoderStart:
+		if ctrl.ctype != CONTROL_START {
+			return out, ErrControlFrameUnexpected
+		}
+		if !ctrl.CheckContentType(d.ctype) {
+			return out, ErrControlFrameContentTypeUnsupported
+		}
+		d.state = decoderData
+		return out, nil
+
+	default:
+		if ctrl.ctype != CONTROL_STOP {
+			return out, ErrControlFrameUnexpected
+		}
+		if d.handshake {
+			out = append(out, NewControlFrameFrame(NewControlFrame(CONTROL_FINISH)).WireBytes()...)
+		}
+		return out, io.EOF
+	}
+}
@@ -0,0 +1,121 @@
+package framestream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func decoderStream(handshake bool, payloads ...[]byte) []byte {
+	var buf bytes.Buffer
+	if handshake {
+		NewControlFrameFrame(NewControlFrame(CONTROL_READY, []byte("ctype"))).WriteTo(&buf)
+	}
+	NewControlFrameFrame(NewControlFrame(CONTROL_START, []byte("ctype"))).WriteTo(&buf)
+	for _, payload := range payloads {
+		NewDataFrame(payload).WriteTo(&buf)
+	}
+	NewControlFrameFrame(NewControlFrame(CONTROL_STOP)).WriteTo(&buf)
+	return buf.Bytes()
+}
+
+func TestDecoder_Feed(t *testing.T) {
+	stream := decoderStream(true, []byte{1, 2, 3}, []byte{4})
+	accept := NewControlFrameFrame(NewControlFrame(CONTROL_ACCEPT, []byte("ctype"))).WireBytes()
+	finish := NewControlFrameFrame(NewControlFrame(CONTROL_FINISH)).WireBytes()
+
+	for _, chunkSize := range []int{1, 3, 7, len(stream)} {
+		var payloads [][]byte
+		controls := 0
+		d := NewDecoder([]byte("ctype"), true, func(frame *Frame) error {
+			if frame.IsControl() {
+				controls++
+			} else {
+				payloads = append(payloads, frame.Payload())
+			}
+			return nil
+		})
+
+		var out []byte
+		var err error
+		for i := 0; i < len(stream) && err == nil; i += chunkSize {
+			var answer []byte
+			answer, err = d.Feed(stream[i:min(i+chunkSize, len(stream))])
+			out = append(out, answer...)
+		}
+		if err != io.EOF {
+			t.Fatalf("chunk %d: expected io.EOF, got %v", chunkSize, err)
+		}
+		if !bytes.Equal(out, append(append([]byte{}, accept...), finish...)) {
+			t.Errorf("chunk %d: unexpected answer %v", chunkSize, out)
+		}
+		if controls != 3 || len(payloads) != 2 || !bytes.Equal(payloads[0], []byte{1, 2, 3}) || !bytes.Equal(payloads[1], []byte{4}) {
+			t.Errorf("chunk %d: unexpected frames %d %v", chunkSize, controls, payloads)
+		}
+		if _, err := d.Feed([]byte{0}); err != io.EOF {
+			t.Errorf("chunk %d: expected io.EOF after stop, got %v", chunkSize, err)
+		}
+	}
+}
+
+func TestDecoder_Unidirectional(t *testing.T) {
+	frames := 0
+	d := NewDecoder([]byte("ctype"), false, func(frame *Frame) error {
+		frames++
+		return nil
+	})
+	out, err := d.Feed(decoderStream(false, []byte{1}))
+	if err != io.EOF || len(out) != 0 || frames != 3 {
+		t.Errorf("unexpected result: %v %v %d", err, out, frames)
+	}
+}
+
+func TestDecoder_DefaultLimits(t *testing.T) {
+	frames := 0
+	d := NewDecoder([]byte("ctype"), false, func(frame *Frame) error {
+		frames++
+		return nil
+	})
+	// zero selects the defaults, as with readFrame
+	d.SetDataFrameMaxLength(0)
+	d.SetControlFrameMaxLength(0)
+	out, err := d.Feed(decoderStream(false, []byte{1}))
+	if err != io.EOF || len(out) != 0 || frames != 3 {
+		t.Errorf("unexpected result: %v %v %d", err, out, frames)
+	}
+}
+
+func TestDecoder_Errors(t *testing.T) {
+	handler := func(frame *Frame) error { return nil }
+
+	// data frame before the handshake
+	d := NewDecoder([]byte("ctype"), true, handler)
+	if _, err := d.Feed([]byte{0, 0, 0, 1, 1}); err != ErrControlFrameExpected {
+		t.Errorf("expected ErrControlFrameExpected, got %v", err)
+	}
+
+	// content type mismatch
+	d = NewDecoder([]byte("other"), true, handler)
+	if _, err := d.Feed(decoderStream(true)); err != ErrControlFrameContentTypeUnsupported {
+		t.Errorf("expected ErrControlFrameContentTypeUnsupported, got %v", err)
+	}
+
+	// frame too large, detected from the header
+	d = NewDecoder([]byte("ctype"), false, handler)
+	d.SetDataFrameMaxLength(8)
+	d.Feed(NewControlFrameFrame(NewControlFrame(CONTROL_START, []byte("ctype"))).WireBytes())
+	if _, err := d.Feed([]byte{0, 0, 0, 9}); err != ErrFrameTooLarge {
+		t.Errorf("expected ErrFrameTooLarge, got %v", err)
+	}
+	if _, err := d.Feed(nil); err != ErrFrameTooLarge {
+		t.Errorf("expected error to persist, got %v", err)
+	}
+
+	// handler error
+	errHandler := errors.New("handler")
+	d = NewDecoder([]byte("ctype"), false, func(frame *Frame) error { return errHandler })
+	if _, err := d.Feed(decoderStream(false)); err != errHandler {
+		t.Errorf("expected handler error, got %v", err)
+	}
+}
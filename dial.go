@@ -0,0 +1,105 @@
+package framestream
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// default time allowed to the bidirectional handshake before the fallback
+const DefaultHandshakeTimeout = 5 * time.Second
+
+type DialOptions struct {
+	Network     string
+	Address     string
+	ContentType []byte
+	ReadTimeout time.Duration
+	DialTimeout time.Duration
+	// HandshakeFallback tries the bidirectional handshake first and
+	// reconnects unidirectionally when it fails
+	Handshake HandshakeMode
+	// time allowed to the bidirectional handshake with the fallback mode,
+	// DefaultHandshakeTimeout by default
+	HandshakeTimeout time.Duration
+	// custom dialer, net.DialTimeout by default
+	Dial func(network, address string) (net.Conn, error)
+	// Configure sets up each session before its handshake, the extensions
+	// for example, and runs again on the fallback connection
+	Configure func(fs *Fstrm)
+}
+
+// DialFstrm connects to a receiver and runs the sender handshake
+func DialFstrm(opts DialOptions) (*Fstrm, error) {
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.HandshakeTimeout <= 0 {
+		opts.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if opts.Dial == nil {
+		opts.Dial = func(network, address string) (net.Conn, error) {
+			return net.DialTimeout(network, address, opts.DialTimeout)
+		}
+	}
+
+	if opts.Handshake != HandshakeFallback {
+		return dialSender(opts, opts.Handshake)
+	}
+
+	// bidirectional handshake first
+	fs, err := dialSender(opts, HandshakeBidirectional)
+	if err == nil || !isHandshakeFailure(err) {
+		return fs, err
+	}
+
+	// reconnect without handshake
+	fs, err = dialSender(opts, HandshakeUnidirectional)
+	if err != nil {
+		return nil, err
+	}
+	fs.session.mu.Lock()
+	fs.session.fallback = true
+	fs.session.mu.Unlock()
+	return fs, nil
+}
+
+func dialSender(opts DialOptions, mode HandshakeMode) (*Fstrm, error) {
+	conn, err := opts.Dial(opts.Network, opts.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := NewFstrm(bufio.NewReader(conn), bufio.NewWriter(conn), conn, opts.ReadTimeout, opts.ContentType, mode != HandshakeUnidirectional)
+	if opts.Configure != nil {
+		opts.Configure(fs)
+	}
+
+	// bound the bidirectional handshake when the fallback is possible
+	readtimeout, writetimeout := fs.readtimeout, fs.writetimeout
+	if opts.Handshake == HandshakeFallback && mode == HandshakeBidirectional {
+		fs.readtimeout, fs.writetimeout = opts.HandshakeTimeout, opts.HandshakeTimeout
+	}
+	if err := fs.InitSender(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	fs.readtimeout, fs.writetimeout = readtimeout, writetimeout
+	return fs, nil
+}
+
+// isHandshakeFailure reports whether the peer did not answer READY,
+// either by timeout or by closing the connection
+func isHandshakeFailure(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.ErrClosedPipe) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
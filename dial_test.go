@@ -0,0 +1,115 @@
+package framestream
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// collector receiving one frame, with a broken handshake on the first connection
+func fallbackCollector(t *testing.T, first func(conn net.Conn), received chan []byte) func(network, address string) (net.Conn, error) {
+	dials := 0
+	return func(network, address string) (net.Conn, error) {
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close(); server.Close() })
+
+		dials++
+		if dials == 1 && first != nil {
+			go first(server)
+			return client, nil
+		}
+		go func() {
+			fs := NewFstrm(bufio.NewReader(server), bufio.NewWriter(server), server, time.Second, []byte("ctype"), false)
+			fs.SetHandshakeMode(HandshakeAuto)
+			if err := fs.InitReceiver(); err != nil {
+				return
+			}
+			frame, err := fs.RecvFrame(false)
+			if err != nil {
+				return
+			}
+			received <- frame.Data()
+		}()
+		return client, nil
+	}
+}
+
+func TestDialFstrm_Fallback(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		first    func(conn net.Conn)
+		mode     HandshakeMode
+		fallback bool
+	}{
+		{name: "bidirectional", first: nil, mode: HandshakeBidirectional, fallback: false},
+		{name: "no_accept", first: func(conn net.Conn) {
+			// read READY and never answer
+			bufio.NewReader(conn).ReadByte()
+		}, mode: HandshakeUnidirectional, fallback: true},
+		{name: "peer_closed", first: func(conn net.Conn) {
+			conn.Close()
+		}, mode: HandshakeUnidirectional, fallback: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			received := make(chan []byte, 1)
+			configured := 0
+			fs, err := DialFstrm(DialOptions{
+				Address:          "collector",
+				ContentType:      []byte("ctype"),
+				Handshake:        HandshakeFallback,
+				HandshakeTimeout: 100 * time.Millisecond,
+				Dial:             fallbackCollector(t, tc.first, received),
+				Configure: func(fs *Fstrm) {
+					configured++
+					fs.SetWriteTimeout(time.Minute)
+				},
+			})
+			if err != nil {
+				t.Fatalf("error to dial: %s", err)
+			}
+
+			frame := &Frame{}
+			frame.Write([]byte{1, 2, 3})
+			if err := fs.SendFrame(frame); err != nil {
+				t.Fatalf("error to send frame: %s", err)
+			}
+			if payload := <-received; !bytes.Equal(payload, []byte{1, 2, 3}) {
+				t.Errorf("unexpected payload: %v", payload)
+			}
+
+			info := fs.SessionInfo()
+			if info.Handshake != tc.mode || info.HandshakeFallback != tc.fallback {
+				t.Errorf("unexpected handshake: %s fallback=%v", info.Handshake, info.HandshakeFallback)
+			}
+
+			// once per connection, the settings kept after the handshake
+			dials := 1
+			if tc.fallback {
+				dials = 2
+			}
+			if configured != dials {
+				t.Errorf("expected %d configured sessions, got %d", dials, configured)
+			}
+			if fs.writetimeout != time.Minute {
+				t.Errorf("write timeout not kept: %s", fs.writetimeout)
+			}
+		})
+	}
+}
+
+func TestDialFstrm_NoFallback(t *testing.T) {
+	_, err := DialFstrm(DialOptions{
+		Address:     "collector",
+		ContentType: []byte("ctype"),
+		Handshake:   HandshakeBidirectional,
+		ReadTimeout: 100 * time.Millisecond,
+		Dial: fallbackCollector(t, func(conn net.Conn) {
+			conn.Close()
+		}, nil),
+	})
+	if err == nil {
+		t.Fatalf("expected handshake error without fallback")
+	}
+}
@@ -0,0 +1,159 @@
+package framestream
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// default size of the queue of each worker
+const DefaultDispatcherQueueSize = 1024
+
+var ErrDispatcherClosed = errors.New("dispatcher closed")
+
+/* Handler of the data frames received by a dispatcher */
+type Handler interface {
+	HandleFrame(fs *Fstrm, frame *Frame)
+}
+
+type HandlerFunc func(fs *Fstrm, frame *Frame)
+
+func (f HandlerFunc) HandleFrame(fs *Fstrm, frame *Frame) {
+	f(fs, frame)
+}
+
+type DispatcherOptions struct {
+	// number of workers, runtime.NumCPU() by default
+	Workers int
+	// frames queued per worker, DefaultDispatcherQueueSize by default
+	QueueSize int
+	// behavior when the queue of a worker is full, blocking stops
+	// reading the session so that TCP backpressure reaches the peer
+	Policy OverflowPolicy
+}
+
+/* Dispatcher statistics */
+type DispatcherStats struct {
+	Dispatched uint64
+	Dropped    uint64
+}
+
+type dispatchItem struct {
+	fs    *Fstrm
+	frame *Frame
+}
+
+/*
+Receive dispatcher
+
+Frames read from the sessions are handled by a bounded pool of workers.
+Each session is bound to one worker so that its frames are handled in order,
+sessions are spread across the workers. With acknowledgements, a frame is
+acknowledged once HandleFrame returns; a frame dropped on overflow is
+acknowledged too, the drop is final and counted in the statistics.
+*/
+type Dispatcher struct {
+	handler    Handler
+	policy     OverflowPolicy
+	queues     []chan dispatchItem
+	next       atomic.Uint64
+	dispatched atomic.Uint64
+	dropped    atomic.Uint64
+	// protects the queues against Close
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewDispatcher(handler Handler, opts DispatcherOptions) *Dispatcher {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultDispatcherQueueSize
+	}
+
+	d := &Dispatcher{handler: handler, policy: opts.Policy}
+	for i := 0; i < opts.Workers; i++ {
+		queue := make(chan dispatchItem, opts.QueueSize)
+		d.queues = append(d.queues, queue)
+		d.wg.Add(1)
+		go d.work(queue)
+	}
+	return d
+}
+
+func (d *Dispatcher) work(queue chan dispatchItem) {
+	defer d.wg.Done()
+	for item := range queue {
+		d.handler.HandleFrame(item.fs, item.frame)
+		item.fs.Ack(item.frame)
+	}
+}
+
+// Serve reads the data frames of an initialized receiver session and
+// dispatches them until the STOP control frame, like ProcessFrame it
+// returns io.EOF at the end of the session.
+func (d *Dispatcher) Serve(fs *Fstrm) error {
+	queue := d.queues[(d.next.Add(1)-1)%uint64(len(d.queues))]
+	for {
+		frame, err := fs.RecvFrame(false)
+		if err != nil {
+			return err
+		}
+		if frame.control {
+			if err := fs.ResetReceiver(frame); err != nil {
+				return err
+			}
+			// control frame tolerated in lenient mode, nothing to dispatch
+			continue
+		}
+		if err := d.dispatch(queue, dispatchItem{fs: fs, frame: frame}); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(queue chan dispatchItem, item dispatchItem) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return ErrDispatcherClosed
+	}
+
+	if d.policy == OverflowDrop {
+		select {
+		case queue <- item:
+		default:
+			d.dropped.Add(1)
+			item.fs.Ack(item.frame)
+			return nil
+		}
+	} else {
+		queue <- item
+	}
+	d.dispatched.Add(1)
+	return nil
+}
+
+func (d *Dispatcher) Stats() DispatcherStats {
+	return DispatcherStats{
+		Dispatched: d.dispatched.Load(),
+		Dropped:    d.dropped.Load(),
+	}
+}
+
+// Close stops accepting frames and waits until the queued ones are handled,
+// sessions still served then fail with ErrDispatcherClosed
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, queue := range d.queues {
+			close(queue)
+		}
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
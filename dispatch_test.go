@@ -0,0 +1,148 @@
+package framestream
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func dispatchSession(t *testing.T, d *Dispatcher, ctype string, payloads [][]byte) chan error {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+
+	go func() {
+		fs_server := NewFstrm(bufio.NewReader(server), bufio.NewWriter(server), server, 5*time.Second, []byte(ctype), true)
+		if err := fs_server.InitSender(); err != nil {
+			return
+		}
+		for _, payload := range payloads {
+			frame := &Frame{}
+			frame.Write(payload)
+			if err := fs_server.SendFrame(frame); err != nil {
+				return
+			}
+		}
+		fs_server.ResetSender()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		fs_client := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 5*time.Second, []byte(ctype), true)
+		if err := fs_client.InitReceiver(); err != nil {
+			done <- err
+			return
+		}
+		done <- d.Serve(fs_client)
+	}()
+	return done
+}
+
+func TestDispatcher_Order(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[*Fstrm][]byte)
+	d := NewDispatcher(HandlerFunc(func(fs *Fstrm, frame *Frame) {
+		mu.Lock()
+		received[fs] = append(received[fs], frame.Data()[0])
+		mu.Unlock()
+	}), DispatcherOptions{Workers: 2, QueueSize: 4})
+
+	var payloads [][]byte
+	for i := 0; i < 100; i++ {
+		payloads = append(payloads, []byte{byte(i)})
+	}
+	sessions := []chan error{
+		dispatchSession(t, d, "ctype", payloads),
+		dispatchSession(t, d, "ctype", payloads),
+		dispatchSession(t, d, "ctype", payloads),
+	}
+	for _, done := range sessions {
+		if err := <-done; err != io.EOF {
+			t.Fatalf("unexpected end of session: %v", err)
+		}
+	}
+	d.Close()
+
+	if len(received) != 3 {
+		t.Fatalf("expected 3 sessions, got %d", len(received))
+	}
+	for _, frames := range received {
+		if len(frames) != 100 {
+			t.Fatalf("expected 100 frames, got %d", len(frames))
+		}
+		for i, b := range frames {
+			if b != byte(i) {
+				t.Fatalf("frame %d out of order", i)
+			}
+		}
+	}
+	if stats := d.Stats(); stats.Dispatched != 300 || stats.Dropped != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestDispatcher_Drop(t *testing.T) {
+	release := make(chan struct{})
+	d := NewDispatcher(HandlerFunc(func(fs *Fstrm, frame *Frame) {
+		<-release
+	}), DispatcherOptions{Workers: 1, QueueSize: 2, Policy: OverflowDrop})
+
+	var payloads [][]byte
+	for i := 0; i < 10; i++ {
+		payloads = append(payloads, []byte{byte(i)})
+	}
+	if err := <-dispatchSession(t, d, "ctype", payloads); err != io.EOF {
+		t.Fatalf("unexpected end of session: %v", err)
+	}
+	close(release)
+	d.Close()
+
+	// one frame in the handler and two queued at most
+	stats := d.Stats()
+	if stats.Dispatched+stats.Dropped != 10 || stats.Dispatched > 3 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestDispatcher_DropAcknowledged(t *testing.T) {
+	release := make(chan struct{})
+	d := NewDispatcher(HandlerFunc(func(fs *Fstrm, frame *Frame) {
+		<-release
+	}), DispatcherOptions{Workers: 1, QueueSize: 1, Policy: OverflowDrop})
+
+	queue := NewAckQueue(0)
+	sender, receiver := pipeSession(t,
+		func(fs *Fstrm) { fs.SetAckQueue(queue) },
+		func(fs *Fstrm) { fs.SetAcknowledgement(AckOptions{Frames: 1, Interval: time.Hour}) })
+
+	done := make(chan error, 1)
+	go func() { done <- d.Serve(receiver) }()
+	for i := 0; i < 3; i++ {
+		sender.SendFrame(NewDataFrame([]byte{byte(i)}))
+	}
+	// the frames are read before the release
+	for d.Stats().Dispatched+d.Stats().Dropped != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	d.Close()
+
+	sender.ResetSender()
+	if err := <-done; err != io.EOF {
+		t.Fatalf("unexpected end of session: %v", err)
+	}
+	if d.Stats().Dropped == 0 || queue.Pending() != 0 {
+		t.Errorf("dropped frame not acknowledged: %+v, %d pending", d.Stats(), queue.Pending())
+	}
+}
+
+func TestDispatcher_Closed(t *testing.T) {
+	d := NewDispatcher(HandlerFunc(func(fs *Fstrm, frame *Frame) {}), DispatcherOptions{Workers: 1})
+	d.Close()
+
+	if err := <-dispatchSession(t, d, "ctype", [][]byte{{1}}); err != ErrDispatcherClosed {
+		t.Errorf("expected ErrDispatcherClosed, got %v", err)
+	}
+}
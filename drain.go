@@ -0,0 +1,73 @@
+package framestream
+
+import (
+	"context"
+	"time"
+)
+
+// Close drains and ends the sender session: frames pending in the spool are
+// sent, the writer is flushed, STOP is sent and, in bidirectional mode, FINISH
+// is awaited. The context bounds the whole drain in place of the read timeout.
+// The connection is always closed, undelivered is the number of frames left in the spool
+// and, with acknowledgements, not acknowledged.
+func (fs *Fstrm) Close(ctx context.Context) (undelivered int, err error) {
+	if fs.conn != nil {
+		// abort blocked reads and writes once the context is done
+		stop := context.AfterFunc(ctx, func() {
+			fs.conn.SetDeadline(time.Unix(1, 0))
+		})
+		defer stop()
+	}
+
+	err = fs.drain(ctx)
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	if fs.spool != nil {
+		undelivered = fs.spool.Pending()
+	}
+	if fs.ack.active {
+		undelivered += fs.ack.queue.Pending()
+	}
+	if fs.conn != nil {
+		if cerr := fs.conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return undelivered, err
+}
+
+func (fs *Fstrm) drain(ctx context.Context) error {
+	// send frames waiting in the spool
+	if err := fs.replaySpool(ctx); err != nil {
+		return err
+	}
+
+	fs.wmu.Lock()
+	err := fs.writer.Flush()
+	fs.wmu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// send stop control frame
+	if err := fs.SendControl(&ControlFrame{ctype: CONTROL_STOP}); err != nil {
+		return err
+	}
+
+	// wait finish control, without the read timeout
+	if fs.handshake {
+		ctrl, err := fs.recvFinish(false)
+		if err != nil {
+			return err
+		}
+		if ctrl.ctype != CONTROL_FINISH {
+			return ErrControlFrameUnexpected
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package framestream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// drainSession connects a sender with frames waiting in its spool
+func drainSession(t *testing.T, frames int) (*Fstrm, *Fstrm) {
+	sp, err := OpenSpool(SpoolOptions{Dir: t.TempDir(), ContentType: []byte("ctype")})
+	if err != nil {
+		t.Fatalf("error to open spool: %s", err)
+	}
+	t.Cleanup(func() { sp.Close() })
+	for i := 0; i < frames; i++ {
+		sp.Append([]byte{byte(i)})
+	}
+	return pipeSession(t, func(fs *Fstrm) { fs.SetSpool(sp) }, nil)
+}
+
+func TestClose_Drain(t *testing.T) {
+	fs_server, fs_client := drainSession(t, 3)
+
+	received := make(chan int, 1)
+	go func() {
+		n := 0
+		for {
+			frame, err := fs_client.RecvFrame(false)
+			if err != nil {
+				break
+			}
+			if frame.IsControl() {
+				fs_client.ResetReceiver(frame)
+				break
+			}
+			n++
+		}
+		received <- n
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	undelivered, err := fs_server.Close(ctx)
+	if err != nil {
+		t.Fatalf("error to close sender: %s", err)
+	}
+	if undelivered != 0 {
+		t.Errorf("expected all frames delivered, %d left", undelivered)
+	}
+	if n := <-received; n != 3 {
+		t.Errorf("expected 3 frames received, got %d", n)
+	}
+}
+
+func TestClose_Timeout(t *testing.T) {
+	fs_server, fs_client := drainSession(t, 0)
+
+	// peer reads STOP but never answers FINISH
+	go fs_client.RecvFrame(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := fs_server.Close(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("close not bounded by the context: %s", time.Since(start))
+	}
+}
+
+func TestClose_Undelivered(t *testing.T) {
+	fs_server, fs_client := drainSession(t, 3)
+
+	// peer gone before the drain
+	fs_client.conn.Close()
+
+	undelivered, err := fs_server.Close(context.Background())
+	if err == nil {
+		t.Fatalf("expected error on closed peer")
+	}
+	if undelivered != 3 {
+		t.Errorf("expected 3 undelivered frames, got %d", undelivered)
+	}
+}
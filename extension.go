@@ -0,0 +1,119 @@
+package framestream
+
+/*
+Extensions are negotiated during the bidirectional handshake: the sender
+offers them as extension fields in READY, packed in an extra content type,
+the receiver enables those it supports and acknowledges them with the same
+fields in ACCEPT. Peers that don't offer or acknowledge an extension keep
+the standard protocol.
+
+Without the handshake nothing can be negotiated: the checksum, the only
+extension a unidirectional stream supports, is announced by the sender in
+START and used by the receiver as soon as it reads it.
+*/
+
+// frameOverhead returns the bytes added to each data frame payload
+// by the negotiated extensions
+func (fs *Fstrm) frameOverhead() int {
+	size := 0
+	if fs.sequenced() {
+		size += sequenceLength
+	}
+	if fs.fragmentation {
+		size++
+	}
+	if fs.checksum {
+		size += 4
+	}
+	return size
+}
+
+// readyFields returns the extension fields offered by the sender in READY
+func (fs *Fstrm) readyFields() []ControlField {
+	var fields []ControlField
+	if fs.checksumEnabled {
+		fields = append(fields, ControlField{Type: CONTROL_FIELD_CHECKSUM, Value: []byte(checksumCRC32C)})
+	}
+	if fs.frameSize.policy != FrameSizeIgnore {
+		fields = append(fields, ControlField{Type: CONTROL_FIELD_MAX_FRAME_SIZE})
+	}
+	if fs.fragmentationEnabled {
+		fields = append(fields, ControlField{Type: CONTROL_FIELD_FRAGMENTATION})
+	}
+	if fs.ack.queue != nil {
+		fields = append(fields, ControlField{Type: CONTROL_FIELD_ACKNOWLEDGE})
+	}
+	if fs.seq.enabled {
+		fields = append(fields, ControlField{Type: CONTROL_FIELD_SEQUENCING})
+	}
+	if fs.auth.key != nil {
+		fields = append(fields, ControlField{Type: CONTROL_FIELD_AUTH_KEY_ID, Value: []byte(fs.auth.keyID)})
+	}
+	return fields
+}
+
+// startFields enables on a sender without handshake the extensions announced
+// in START, the receiver can't refuse them
+func (fs *Fstrm) startFields() []ControlField {
+	var fields []ControlField
+	if fs.checksumEnabled {
+		fs.checksum = true
+		fields = append(fields, ControlField{Type: CONTROL_FIELD_CHECKSUM, Value: []byte(checksumCRC32C)})
+	}
+	return fields
+}
+
+// acceptFields enables on the receiver the extensions offered in READY
+// and returns the fields acknowledging them in ACCEPT
+func (fs *Fstrm) acceptFields(ready *ControlFrame) ([]ControlField, error) {
+	var fields []ControlField
+	if fs.checksumEnabled && offersChecksum(ready) {
+		fs.checksum = true
+		fields = append(fields, ControlField{Type: CONTROL_FIELD_CHECKSUM, Value: []byte(checksumCRC32C)})
+	}
+	if _, ok := ready.Field(CONTROL_FIELD_MAX_FRAME_SIZE); ok {
+		limit := fs.dataFrameMaxLength
+		if limit == 0 {
+			limit = DefaultDataFrameMaxLength
+		}
+		fields = append(fields, ControlField{Type: CONTROL_FIELD_MAX_FRAME_SIZE, Value: encodeFrameSize(limit)})
+	}
+	if _, ok := ready.Field(CONTROL_FIELD_FRAGMENTATION); ok && fs.fragmentationEnabled {
+		fs.fragmentation = true
+		fields = append(fields, ControlField{Type: CONTROL_FIELD_FRAGMENTATION, Value: fs.fragmentationLimits()})
+	}
+	if _, ok := ready.Field(CONTROL_FIELD_ACKNOWLEDGE); ok && fs.ack.enabled {
+		fs.ack.active = true
+		fields = append(fields, ControlField{Type: CONTROL_FIELD_ACKNOWLEDGE})
+	}
+	if _, ok := ready.Field(CONTROL_FIELD_SEQUENCING); ok && fs.seq.enabled {
+		fs.seq.active = true
+		fields = append(fields, ControlField{Type: CONTROL_FIELD_SEQUENCING})
+	}
+	if fs.auth.lookup != nil {
+		field, err := fs.authChallenge(ready)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// negotiate enables on the sender the extensions acknowledged in ACCEPT
+func (fs *Fstrm) negotiate(accept *ControlFrame) error {
+	fs.checksum = fs.checksumEnabled && offersChecksum(accept)
+	if fs.frameSize.policy != FrameSizeIgnore {
+		fs.frameSize.peerMax, _ = decodeFrameSize(accept)
+	}
+	if fs.fragmentationEnabled {
+		fs.fragmentSize, fs.peerMessageMax, fs.fragmentation = decodeFragmentationLimits(accept)
+	}
+	if fs.ack.queue != nil {
+		_, fs.ack.active = accept.Field(CONTROL_FIELD_ACKNOWLEDGE)
+	}
+	if fs.seq.enabled {
+		_, fs.seq.active = accept.Field(CONTROL_FIELD_SEQUENCING)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package framestream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// legacyControl reads a control frame the way receivers without extension
+// support do, any field other than a content type is malformed
+func legacyControl(r io.Reader) (uint32, [][]byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[4:8]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	var ctypes [][]byte
+	fields := body[4:]
+	for len(fields) >= 8 {
+		if binary.BigEndian.Uint32(fields[:4]) != CONTROL_FIELD_CONTENT_TYPE {
+			return 0, nil, ErrControlFrameMalformed
+		}
+		flen := binary.BigEndian.Uint32(fields[4:8])
+		if uint32(len(fields)-8) < flen {
+			return 0, nil, ErrControlFrameMalformed
+		}
+		ctypes = append(ctypes, fields[8:8+flen])
+		fields = fields[8+flen:]
+	}
+	if len(fields) > 0 {
+		return 0, nil, ErrControlFrameMalformed
+	}
+	return binary.BigEndian.Uint32(body[:4]), ctypes, nil
+}
+
+func TestExtensions_LegacyReceiver(t *testing.T) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+
+	// sender offering every extension
+	fs := NewFstrm(bufio.NewReader(server), bufio.NewWriter(server), server, 5*time.Second, []byte("ctype"), true)
+	fs.SetChecksum(true)
+	fs.SetFrameSizePolicy(FrameSizeReject)
+	fs.SetFragmentation(true)
+	fs.SetAckQueue(NewAckQueue(0))
+	fs.SetSequencing(true)
+	fs.SetAuthentication("collector-1", []byte("secret"))
+
+	done := make(chan error, 1)
+	go func() {
+		if err := fs.InitSender(); err != nil {
+			done <- err
+			return
+		}
+		done <- fs.SendFrame(NewDataFrame([]byte("dns")))
+	}()
+
+	reader := bufio.NewReader(client)
+	ctype, ctypes, err := legacyControl(reader)
+	if err != nil || ctype != CONTROL_READY {
+		t.Fatalf("READY rejected by a legacy receiver: %v", err)
+	}
+	found := false
+	for _, ct := range ctypes {
+		found = found || bytes.Equal(ct, []byte("ctype"))
+	}
+	if !found {
+		t.Fatalf("content type not offered in READY: %q", ctypes)
+	}
+
+	accept := &ControlFrame{ctype: CONTROL_ACCEPT, ctypes: [][]byte{[]byte("ctype")}}
+	accept.Encode()
+	client.Write(append([]byte{0, 0, 0, 0}, accept.data...))
+
+	if ctype, _, err := legacyControl(reader); err != nil || ctype != CONTROL_START {
+		t.Fatalf("expected START, got %d: %v", ctype, err)
+	}
+
+	// standard data frame, no extension negotiated
+	frame := make([]byte, 7)
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		t.Fatalf("error to read data frame: %s", err)
+	}
+	if !bytes.Equal(frame, []byte{0, 0, 0, 3, 'd', 'n', 's'}) {
+		t.Errorf("unexpected data frame: %v", frame)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("error to init framestream sender: %s", err)
+	}
+	if fs.ChecksumActive() || fs.FragmentationActive() || fs.AckActive() || fs.seq.active {
+		t.Errorf("extension active without being accepted")
+	}
+}
+
+func TestExtensions_ReadyRoundTrip(t *testing.T) {
+	ready := &ControlFrame{ctype: CONTROL_READY, ctypes: [][]byte{[]byte("ctype")}}
+	ready.AddField(CONTROL_FIELD_CHECKSUM, []byte(checksumCRC32C))
+	ready.AddField(CONTROL_FIELD_SEQUENCING, nil)
+
+	decoded := &ControlFrame{}
+	if err := decoded.UnmarshalBinary(ready.Bytes()); err != nil {
+		t.Fatalf("error to decode READY: %s", err)
+	}
+	if len(decoded.ContentTypes()) != 1 || !decoded.CheckContentType([]byte("ctype")) {
+		t.Errorf("unexpected content types: %q", decoded.ContentTypes())
+	}
+	if value, ok := decoded.Field(CONTROL_FIELD_CHECKSUM); !ok || string(value) != checksumCRC32C {
+		t.Errorf("checksum field not decoded")
+	}
+	if _, ok := decoded.Field(CONTROL_FIELD_SEQUENCING); !ok {
+		t.Errorf("sequencing field not decoded")
+	}
+
+	// unknown packed fields are skipped
+	unknown := &ControlFrame{ctype: CONTROL_READY, ctypes: [][]byte{append(append([]byte{}, extensionContentType...), 0, 0, 0x90, 0, 0, 0, 0, 1, 7)}}
+	unknown.Encode()
+	decoded = &ControlFrame{}
+	if err := decoded.UnmarshalBinary(unknown.data); err != nil {
+		t.Fatalf("error to decode READY with an unknown field: %s", err)
+	}
+	if len(decoded.fields) != 0 || len(decoded.ContentTypes()) != 0 {
+		t.Errorf("unknown field not skipped: %v", decoded.fields)
+	}
+
+	// packed fields must fit in the content type
+	bad := &ControlFrame{ctype: CONTROL_READY, ctypes: [][]byte{append(append([]byte{}, extensionContentType...), 0, 0, 0x80, 1, 0, 0, 0, 4, 1)}}
+	bad.Encode()
+	if err := (&ControlFrame{}).UnmarshalBinary(bad.data); !errors.Is(err, ErrControlFrameMalformed) {
+		t.Errorf("expected ErrControlFrameMalformed, got %v", err)
+	}
+}
+
+func TestExtensions_ReadyWithoutFields(t *testing.T) {
+	ready := &ControlFrame{ctype: CONTROL_READY, ctypes: [][]byte{[]byte("ctype")}}
+
+	// no packed content type, only the frame buffer is allocated
+	allocs := testing.AllocsPerRun(100, func() { ready.Encode() })
+	if allocs != 1 {
+		t.Errorf("expected 1 allocation, got %v", allocs)
+	}
+	if len(ready.data) != 4+4+8+len("ctype") {
+		t.Errorf("unexpected READY length: %d", len(ready.data))
+	}
+}
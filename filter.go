@@ -0,0 +1,182 @@
+package framestream
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// returned internally when a data frame is discarded by a filter
+var errFrameFiltered = errors.New("frame filtered")
+
+/*
+Receive filter
+
+Filters are applied to data frames in the order they are added, before the
+payload is copied. A frame discarded by a filter is skipped on the wire and
+never delivered, neither recorded in the trace nor counted in the session info.
+*/
+type Filter interface {
+	// PrefixLength returns the number of payload bytes needed by Keep
+	PrefixLength() int
+	// Keep reports whether the frame is delivered, the prefix holds the first
+	// bytes of the payload and is shorter when the payload is smaller
+	Keep(prefix []byte) bool
+}
+
+/* Filter statistics */
+type FilterStats struct {
+	Name    string
+	Dropped uint64
+}
+
+type filterEntry struct {
+	name    string
+	filter  Filter
+	dropped atomic.Uint64
+}
+
+// AddFilter appends a filter to the receive path of the session,
+// the name identifies it in the statistics
+func (fs *Fstrm) AddFilter(name string, filter Filter) {
+	fs.filters = append(fs.filters, &filterEntry{name: name, filter: filter})
+}
+
+// FilterStats returns the number of frames dropped by each filter
+func (fs *Fstrm) FilterStats() []FilterStats {
+	stats := make([]FilterStats, 0, len(fs.filters))
+	for _, entry := range fs.filters {
+		stats = append(stats, FilterStats{Name: entry.name, Dropped: entry.dropped.Load()})
+	}
+	return stats
+}
+
+// filter runs the filters on the data frame of the given size waiting in the
+// reader, and discards it when dropped
+func (fs *Fstrm) filter(size int) error {
+	payloadLen := size
+	// without the checksum trailer
+	if fs.checksum {
+		payloadLen -= 4
+	}
+
+	// sequence number and fragment flag before the payload
+	headerLen := 0
+	if fs.sequenced() {
+		headerLen += 8
+	}
+	if fs.fragmentation {
+		headerLen++
+	}
+
+	prefixLen := 0
+	for _, entry := range fs.filters {
+		prefixLen = max(prefixLen, entry.filter.PrefixLength())
+	}
+	prefixLen = max(min(prefixLen+headerLen, payloadLen, fs.reader.Size()), 0)
+
+	prefix, err := fs.reader.Peek(prefixLen)
+	if err != nil {
+		return err
+	}
+	// frames shorter than their header fail once read
+	if len(prefix) < headerLen {
+		return nil
+	}
+
+	var seq uint64
+	if fs.sequenced() {
+		seq = binary.BigEndian.Uint64(prefix[:8])
+		prefix = prefix[8:]
+		if fs.ack.active {
+			fs.ackReceived(seq)
+		}
+	}
+
+	// fragmented messages are filtered on their first fragment
+	more := false
+	if fs.fragmentation {
+		more = prefix[0] == fragmentMore
+		prefix = prefix[1:]
+		if fs.dropFragments {
+			fs.dropFragments = more
+			return fs.discard(size, seq)
+		}
+		if fs.assembling {
+			return nil
+		}
+	}
+
+	for _, entry := range fs.filters {
+		if !entry.filter.Keep(prefix) {
+			entry.dropped.Add(1)
+			fs.dropFragments = more
+			return fs.discard(size, seq)
+		}
+	}
+	return nil
+}
+
+// discard skips a frame waiting in the reader, its sequence
+// is tracked and acknowledged as handled
+func (fs *Fstrm) discard(size int, seq uint64) error {
+	if _, err := fs.reader.Discard(size); err != nil {
+		return err
+	}
+	if seq != 0 {
+		fs.trackSequence(seq)
+	}
+	if fs.ack.active && seq != 0 {
+		fs.acknowledge(seq, seq)
+	}
+	return errFrameFiltered
+}
+
+type sampleFilter struct {
+	n     uint64
+	count atomic.Uint64
+}
+
+// NewSampleFilter keeps one data frame out of n
+func NewSampleFilter(n int) Filter {
+	return &sampleFilter{n: uint64(max(n, 1))}
+}
+
+func (f *sampleFilter) PrefixLength() int { return 0 }
+
+func (f *sampleFilter) Keep(prefix []byte) bool {
+	return (f.count.Add(1)-1)%f.n == 0
+}
+
+type probabilityFilter struct {
+	p float64
+}
+
+// NewProbabilityFilter keeps each data frame with the probability p, between 0 and 1
+func NewProbabilityFilter(p float64) Filter {
+	return &probabilityFilter{p: p}
+}
+
+func (f *probabilityFilter) PrefixLength() int { return 0 }
+
+func (f *probabilityFilter) Keep(prefix []byte) bool {
+	return rand.Float64() < f.p
+}
+
+type prefixFilter struct {
+	length int
+	keep   func(prefix []byte) bool
+}
+
+// NewPrefixFilter keeps the data frames accepted by keep, called with
+// the first length bytes of the payload
+func NewPrefixFilter(length int, keep func(prefix []byte) bool) Filter {
+	return &prefixFilter{length: length, keep: keep}
+}
+
+func (f *prefixFilter) PrefixLength() int { return f.length }
+
+func (f *prefixFilter) Keep(prefix []byte) bool {
+	return f.keep(prefix)
+}
@@ -0,0 +1,88 @@
+package framestream
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func filterStream(t *testing.T, payloads [][]byte) *Fstrm {
+	var buf bytes.Buffer
+	for _, payload := range payloads {
+		frame := &Frame{}
+		if err := frame.Write(payload); err != nil {
+			t.Fatalf("error to write frame: %s", err)
+		}
+		buf.Write(frame.data)
+	}
+	return NewFstrm(bufio.NewReader(&buf), nil, nil, 0, []byte("ctype"), false)
+}
+
+func recvAll(fs *Fstrm) [][]byte {
+	var payloads [][]byte
+	for {
+		frame, err := fs.RecvFrame(false)
+		if err != nil {
+			return payloads
+		}
+		payloads = append(payloads, frame.Data())
+	}
+}
+
+func TestFilter_Sample(t *testing.T) {
+	var payloads [][]byte
+	for i := 0; i < 10; i++ {
+		payloads = append(payloads, []byte{byte(i)})
+	}
+	fs := filterStream(t, payloads)
+	fs.AddFilter("sample", NewSampleFilter(3))
+
+	received := recvAll(fs)
+	if len(received) != 4 {
+		t.Fatalf("expected 4 frames, got %d", len(received))
+	}
+	for i, payload := range received {
+		if payload[0] != byte(i*3) {
+			t.Errorf("unexpected frame %d: %v", i, payload)
+		}
+	}
+	if stats := fs.FilterStats(); len(stats) != 1 || stats[0].Name != "sample" || stats[0].Dropped != 6 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestFilter_Probability(t *testing.T) {
+	payloads := make([][]byte, 100)
+	for i := range payloads {
+		payloads[i] = []byte{1}
+	}
+
+	fs := filterStream(t, payloads)
+	fs.AddFilter("none", NewProbabilityFilter(0))
+	if received := recvAll(fs); len(received) != 0 {
+		t.Errorf("expected no frame, got %d", len(received))
+	}
+
+	fs = filterStream(t, payloads)
+	fs.AddFilter("all", NewProbabilityFilter(1))
+	if received := recvAll(fs); len(received) != 100 {
+		t.Errorf("expected all frames, got %d", len(received))
+	}
+}
+
+func TestFilter_Prefix(t *testing.T) {
+	fs := filterStream(t, [][]byte{{1, 2, 3}, {2, 2}, {1}, {3, 1, 2, 3}})
+	fs.AddFilter("type", NewPrefixFilter(2, func(prefix []byte) bool {
+		return len(prefix) > 0 && prefix[0] == 1
+	}))
+	fs.AddFilter("sample", NewSampleFilter(1))
+
+	received := recvAll(fs)
+	if len(received) != 2 || !bytes.Equal(received[0], []byte{1, 2, 3}) || !bytes.Equal(received[1], []byte{1}) {
+		t.Fatalf("unexpected frames: %v", received)
+	}
+	stats := fs.FilterStats()
+	if stats[0].Dropped != 2 || stats[1].Dropped != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
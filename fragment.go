@@ -0,0 +1,166 @@
+package framestream
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// default size cap of a reassembled message
+const DefaultMessageMaxLength = 16 * 1024 * 1024
+
+// fragment flag, first byte of each data frame once negotiated
+const (
+	fragmentLast = 0x00
+	fragmentMore = 0x01
+)
+
+var ErrMessageTooLarge = errors.New("fragmented message too large")
+var ErrFragmentMalformed = errors.New("fragment malformed")
+var ErrFragmentIncomplete = errors.New("fragmented message incomplete")
+
+// returned internally when a fragment is stored for reassembly
+var errFrameFragment = errors.New("frame fragment")
+
+/*
+Fragmentation
+
+Once negotiated, each data frame starts with a flag byte telling whether
+more fragments of the message follow. The sender splits the payloads larger
+than the data frame limit of the receiver, which reassembles them under its
+message cap and delivers a single payload.
+*/
+
+// SetFragmentation offers (sender) or accepts (receiver) the fragmentation extension
+func (fs *Fstrm) SetFragmentation(enabled bool) {
+	fs.fragmentationEnabled = enabled
+}
+
+// SetMessageMaxLength sets the size cap of reassembled messages on the receiver,
+// DefaultMessageMaxLength by default
+func (fs *Fstrm) SetMessageMaxLength(length uint32) {
+	fs.messageMaxLength = length
+}
+
+// FragmentationActive reports whether the fragmentation extension was negotiated
+func (fs *Fstrm) FragmentationActive() bool {
+	return fs.fragmentation
+}
+
+// fragmentationLimits returns the limits advertised by the receiver in ACCEPT:
+// the data frame limit then the message cap
+func (fs *Fstrm) fragmentationLimits() []byte {
+	frameMax, messageMax := fs.dataFrameMaxLength, fs.messageMaxLength
+	if frameMax == 0 {
+		frameMax = DefaultDataFrameMaxLength
+	}
+	if messageMax == 0 {
+		messageMax = DefaultMessageMaxLength
+	}
+	value := binary.BigEndian.AppendUint32(nil, frameMax)
+	return binary.BigEndian.AppendUint32(value, messageMax)
+}
+
+func decodeFragmentationLimits(ctrl *ControlFrame) (uint32, uint32, bool) {
+	value, ok := ctrl.Field(CONTROL_FIELD_FRAGMENTATION)
+	if !ok || len(value) != 8 {
+		return 0, 0, false
+	}
+	frameMax, messageMax := binary.BigEndian.Uint32(value[:4]), binary.BigEndian.Uint32(value[4:])
+	// room for the flag, the sequence number and the checksum trailer
+	if frameMax <= 1+sequenceLength+4 {
+		return 0, 0, false
+	}
+	return frameMax, messageMax, true
+}
+
+// fragmentChunkSize returns the payload size carried by each fragment
+func (fs *Fstrm) fragmentChunkSize() int {
+	limit := fs.fragmentSize
+	if fs.frameSize.peerMax > 0 && fs.frameSize.peerMax < limit {
+		limit = fs.frameSize.peerMax
+	}
+	size := int(limit) - fs.frameOverhead()
+	return max(size, 1)
+}
+
+// sendFragmented sends a data frame as one or more fragments, the rate
+// limit applies to the whole message
+func (fs *Fstrm) sendFragmented(frame *Frame) error {
+	payload := frame.data[4:]
+	if fs.peerMessageMax > 0 && len(payload) > int(fs.peerMessageMax) {
+		return &FrameSizeError{Size: len(payload), Limit: int(fs.peerMessageMax)}
+	}
+	if fs.sendLimiter != nil && !fs.sendLimiter.wait(len(frame.data)) {
+		return ErrFrameThrottled
+	}
+
+	fs.fmu.Lock()
+	defer fs.fmu.Unlock()
+
+	size := fs.fragmentChunkSize()
+	var result error
+	for first := true; first || len(payload) > 0; first = false {
+		chunk := payload[:min(size, len(payload))]
+		payload = payload[len(chunk):]
+
+		flag := byte(fragmentLast)
+		if len(payload) > 0 {
+			flag = fragmentMore
+		}
+		fragment := &Frame{}
+		fragment.Write(append([]byte{flag}, chunk...))
+
+		// the remaining fragments follow in the spool
+		var err error
+		if fs.spool != nil {
+			err = fs.sendSpooled(fragment)
+		} else {
+			err = fs.writeFrame(fragment)
+		}
+		if err == ErrFrameSpooled {
+			result = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return result
+}
+
+// reassemble strips the flag of a received data frame, it returns
+// errFrameFragment while the message is incomplete
+func (fs *Fstrm) reassemble(frame *Frame) error {
+	if frame.control {
+		if fs.assembling {
+			return ErrFragmentIncomplete
+		}
+		return nil
+	}
+
+	if len(frame.data) == 0 || frame.data[0] > fragmentMore {
+		return ErrFragmentMalformed
+	}
+	flag, chunk := frame.data[0], frame.data[1:]
+
+	limit := fs.messageMaxLength
+	if limit == 0 {
+		limit = DefaultMessageMaxLength
+	}
+	if len(fs.fragments)+len(chunk) > int(limit) {
+		return ErrMessageTooLarge
+	}
+
+	if flag == fragmentMore {
+		fs.fragments = append(fs.fragments, chunk...)
+		fs.assembling = true
+		return errFrameFragment
+	}
+	if fs.assembling {
+		frame.data = append(fs.fragments, chunk...)
+		fs.fragments, fs.assembling = nil, false
+	} else {
+		frame.data = chunk
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package framestream
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func fragmentSession(t *testing.T, sender, receiver bool, checksum bool) (*Fstrm, *Fstrm) {
+	return pipeSession(t,
+		func(fs *Fstrm) {
+			fs.SetFragmentation(sender)
+			fs.SetChecksum(checksum)
+		},
+		func(fs *Fstrm) {
+			fs.SetDataFrameMaxLength(16)
+			fs.SetMessageMaxLength(256)
+			fs.SetFragmentation(receiver)
+			fs.SetChecksum(checksum)
+		})
+}
+
+func TestFragmentation_Reassembly(t *testing.T) {
+	for _, checksum := range []bool{false, true} {
+		sender, receiver := fragmentSession(t, true, true, checksum)
+		if !sender.FragmentationActive() || !receiver.FragmentationActive() {
+			t.Fatalf("fragmentation not negotiated")
+		}
+
+		payloads := [][]byte{bytes.Repeat([]byte{1}, 100), {2}, {}, bytes.Repeat([]byte{3}, 15)}
+		go func() {
+			for _, payload := range payloads {
+				sender.SendFrame(NewDataFrame(payload))
+			}
+		}()
+		for _, payload := range payloads {
+			frame, err := receiver.RecvFrame(true)
+			if err != nil {
+				t.Fatalf("error to receive frame: %s", err)
+			}
+			if !bytes.Equal(frame.Payload(), payload) {
+				t.Errorf("checksum=%v: unexpected payload of %d bytes", checksum, len(frame.Payload()))
+			}
+		}
+	}
+}
+
+func TestFragmentation_WithSequence(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		enable func(fs *Fstrm, sender bool)
+	}{
+		{name: "sequencing", enable: func(fs *Fstrm, sender bool) { fs.SetSequencing(true) }},
+		{name: "ack", enable: func(fs *Fstrm, sender bool) {
+			if sender {
+				fs.SetAckQueue(NewAckQueue(0))
+			} else {
+				fs.SetAcknowledgement(AckOptions{})
+			}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sender, receiver := pipeSession(t,
+				func(fs *Fstrm) {
+					fs.SetFragmentation(true)
+					fs.SetChecksum(true)
+					tc.enable(fs, true)
+				},
+				func(fs *Fstrm) {
+					fs.SetDataFrameMaxLength(32)
+					fs.SetFragmentation(true)
+					fs.SetChecksum(true)
+					tc.enable(fs, false)
+				})
+
+			// every full fragment fits the limit with the sequence number
+			payload := bytes.Repeat([]byte{1}, 100)
+			go sender.SendFrame(NewDataFrame(payload))
+			frame, err := receiver.RecvFrame(true)
+			if err != nil {
+				t.Fatalf("error to receive frame: %s", err)
+			}
+			if !bytes.Equal(frame.Payload(), payload) {
+				t.Errorf("unexpected payload of %d bytes", len(frame.Payload()))
+			}
+		})
+	}
+}
+
+func TestFragmentation_Negotiation(t *testing.T) {
+	sender, receiver := fragmentSession(t, true, false, false)
+	if sender.FragmentationActive() || receiver.FragmentationActive() {
+		t.Errorf("fragmentation active without receiver support")
+	}
+	sender, receiver = fragmentSession(t, false, true, false)
+	if sender.FragmentationActive() || receiver.FragmentationActive() {
+		t.Errorf("fragmentation active without sender offer")
+	}
+}
+
+func TestFragmentation_MessageTooLarge(t *testing.T) {
+	// refused by the sender with the cap advertised by the receiver
+	sender, _ := fragmentSession(t, true, true, false)
+	err := sender.SendFrame(NewDataFrame(make([]byte, 257)))
+	if !errors.Is(err, ErrFrameExceedsPeerLimit) {
+		t.Errorf("expected ErrFrameExceedsPeerLimit, got %v", err)
+	}
+
+	// enforced by the receiver
+	var buf bytes.Buffer
+	for i := 0; i < 3; i++ {
+		NewDataFrame([]byte{fragmentMore, 1, 2, 3, 4}).WriteTo(&buf)
+	}
+	fs := NewFstrm(bufio.NewReader(&buf), nil, nil, 0, []byte("ctype"), false)
+	fs.fragmentation = true
+	fs.SetMessageMaxLength(10)
+	if _, err := fs.RecvFrame(false); err != ErrMessageTooLarge {
+		t.Errorf("expected ErrMessageTooLarge, got %v", err)
+	}
+}
+
+func TestFragmentation_Filter(t *testing.T) {
+	var buf bytes.Buffer
+	for _, payload := range [][]byte{
+		{fragmentMore, 2, 1}, {fragmentMore, 1}, {fragmentLast, 1},
+		{fragmentMore, 1, 2}, {fragmentLast, 2},
+	} {
+		NewDataFrame(payload).WriteTo(&buf)
+	}
+	NewControlFrameFrame(NewControlFrame(CONTROL_STOP)).WriteTo(&buf)
+
+	fs := NewFstrm(bufio.NewReader(&buf), nil, nil, 0, []byte("ctype"), false)
+	fs.fragmentation = true
+	fs.AddFilter("first", NewPrefixFilter(1, func(prefix []byte) bool {
+		return len(prefix) > 0 && prefix[0] == 1
+	}))
+
+	frame, err := fs.RecvFrame(false)
+	if err != nil || !bytes.Equal(frame.Payload(), []byte{1, 2, 2}) {
+		t.Fatalf("unexpected frame: %v %v", err, frame)
+	}
+	if frame, err := fs.RecvFrame(false); err != nil || !frame.IsControl() {
+		t.Errorf("expected stop control frame: %v", err)
+	}
+	if stats := fs.FilterStats(); stats[0].Dropped != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
@@ -2,6 +2,7 @@ package framestream
 
 import (
 	"encoding/binary"
+	"io"
 )
 
 /*
@@ -15,10 +16,31 @@ import (
 
 If the data length is equal to zero then it's a control frame
 otherwise we have a data frame.
+
+Payload and WireBytes give the same view of a frame whether it was
+built by the sender or read by the receiver.
 */
 type Frame struct {
 	data    []byte
 	control bool
+	// data holds the wire bytes, length prefix included
+	wire bool
+	// sequences confirmed by Ack, fragments of a message included
+	ackFrom, ackTo uint64
+}
+
+// NewDataFrame builds a data frame from its payload
+func NewDataFrame(payload []byte) *Frame {
+	frame := &Frame{}
+	frame.Write(payload)
+	return frame
+}
+
+// NewControlFrameFrame builds the frame carrying a control frame
+func NewControlFrameFrame(ctrl *ControlFrame) *Frame {
+	frame := &Frame{control: true}
+	frame.Write(ctrl.Bytes())
+	return frame
 }
 
 func (frame Frame) Len() int {
@@ -29,10 +51,39 @@ func (frame Frame) IsControl() bool {
 	return frame.control
 }
 
+// Data returns the internal buffer of the frame, the wire bytes after Write
+// and the payload after a read.
+//
+// Deprecated: use Payload or WireBytes.
 func (frame Frame) Data() []byte {
 	return frame.data
 }
 
+// Payload returns the payload of a data frame, or the encoded control frame
+// in the format of ControlFrame.Bytes
+func (frame Frame) Payload() []byte {
+	if frame.wire {
+		return frame.data[4:]
+	}
+	return frame.data
+}
+
+// WireBytes returns the frame as written on the wire
+func (frame Frame) WireBytes() []byte {
+	if frame.wire {
+		return frame.data
+	}
+	return wireBytes(&frame)
+}
+
+// toWire returns a frame holding the wire bytes
+func (frame *Frame) toWire() *Frame {
+	if frame.wire {
+		return frame
+	}
+	return &Frame{data: wireBytes(frame), control: frame.control, wire: true}
+}
+
 func (frame *Frame) Write(payload []byte) error {
 	var flen uint32
 
@@ -51,20 +102,82 @@ func (frame *Frame) Write(payload []byte) error {
 
 	// append payload in the buffer
 	copy(frame.data[4:], payload)
+	frame.wire = true
 
 	return nil
 }
 
+// AppendData appends raw bytes to the buffer, for example
+// to batch encoded frames before compression
 func (frame *Frame) AppendData(payload []byte) error {
 	frame.data = append(frame.data, payload...)
 	return nil
 }
 
+// Encode prefixes the buffer with its length, frames already
+// holding their wire bytes are left as is
 func (frame *Frame) Encode() error {
+	if frame.wire {
+		return nil
+	}
 	length := len(frame.data)
 	newData := make([]byte, 4+length)
 	binary.BigEndian.PutUint32(newData[:4], uint32(length))
 	copy(newData[4:], frame.data)
 	frame.data = newData
+	frame.wire = true
 	return nil
 }
+
+// WriteTo writes the wire bytes of the frame, it implements io.WriterTo
+func (frame *Frame) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(frame.WireBytes())
+	return int64(n), err
+}
+
+// ReadFrame reads exactly one frame with the default size limits of
+// a session, it returns io.EOF when no frame is left
+func (frame *Frame) ReadFrame(r io.Reader) (int64, error) {
+	var header [4]byte
+	n, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return int64(n), err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	maxLength := uint32(DefaultDataFrameMaxLength)
+
+	// control frame, read the control length
+	offset := 4
+	if length == 0 {
+		m, err := io.ReadFull(r, header[:])
+		n += m
+		if err != nil {
+			return int64(n), unexpectedEOF(err)
+		}
+		length = binary.BigEndian.Uint32(header[:])
+		maxLength = DefaultControlFrameMaxLength
+		offset = 8
+	}
+	// the control length counts like in readFrame
+	if offset-4+int(length) > int(maxLength) {
+		return int64(n), ErrFrameTooLarge
+	}
+
+	data := make([]byte, offset+int(length))
+	copy(data[offset-4:], header[:])
+	m, err := io.ReadFull(r, data[offset:])
+	n += m
+	if err != nil {
+		return int64(n), unexpectedEOF(err)
+	}
+
+	frame.data, frame.control, frame.wire = data, offset == 8, true
+	return int64(n), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
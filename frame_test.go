@@ -3,6 +3,8 @@ package framestream
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
+	"io"
 	"testing"
 )
 
@@ -101,3 +103,110 @@ func TestFrameDataIsIndependentCopy(t *testing.T) {
 		t.Error("Frame data are not independent (modification propagated)")
 	}
 }
+
+func TestFrame_PayloadWireBytes(t *testing.T) {
+	payload := []byte{1, 2, 3, 4}
+	wire := []byte{0, 0, 0, 4, 1, 2, 3, 4}
+
+	// built by the sender
+	built := NewDataFrame(payload)
+	if !bytes.Equal(built.Payload(), payload) || !bytes.Equal(built.WireBytes(), wire) {
+		t.Errorf("unexpected built frame: %v %v", built.Payload(), built.WireBytes())
+	}
+
+	// read by the receiver
+	fs := NewFstrm(bufio.NewReader(bytes.NewReader(wire)), nil, nil, 0, nil, false)
+	read, err := fs.RecvFrame(false)
+	if err != nil {
+		t.Fatalf("error to read frame: %s", err)
+	}
+	if !bytes.Equal(read.Payload(), payload) || !bytes.Equal(read.WireBytes(), wire) {
+		t.Errorf("unexpected read frame: %v %v", read.Payload(), read.WireBytes())
+	}
+
+	// encoding twice does not prefix again
+	built.Encode()
+	if !bytes.Equal(built.WireBytes(), wire) {
+		t.Errorf("frame prefixed twice: %v", built.WireBytes())
+	}
+}
+
+func TestFrame_ControlFrame(t *testing.T) {
+	ctrl := NewControlFrame(CONTROL_ACCEPT, []byte("protobuf:dnstap.Dnstap"))
+	frame := NewControlFrameFrame(ctrl)
+	if !frame.IsControl() || !bytes.Equal(frame.Payload(), ctrl_frame_accept) {
+		t.Errorf("unexpected control frame payload: %v", frame.Payload())
+	}
+	if !bytes.Equal(frame.WireBytes(), append([]byte{0, 0, 0, 0}, ctrl_frame_accept...)) {
+		t.Errorf("unexpected control frame wire bytes: %v", frame.WireBytes())
+	}
+
+	fs := NewFstrm(bufio.NewReader(bytes.NewReader(frame.WireBytes())), nil, nil, 0, nil, false)
+	read, err := fs.RecvFrame(false)
+	if err != nil {
+		t.Fatalf("error to read frame: %s", err)
+	}
+	if !read.IsControl() || !bytes.Equal(read.Payload(), frame.Payload()) || !bytes.Equal(read.WireBytes(), frame.WireBytes()) {
+		t.Errorf("unexpected read control frame: %v", read.WireBytes())
+	}
+}
+
+func TestFrame_WriteToReadFrame(t *testing.T) {
+	var buf bytes.Buffer
+	frames := []*Frame{
+		NewControlFrameFrame(NewControlFrame(CONTROL_START, []byte("ctype"))),
+		NewDataFrame([]byte{1, 2, 3}),
+		NewControlFrameFrame(NewControlFrame(CONTROL_STOP)),
+	}
+	for _, frame := range frames {
+		if _, err := frame.WriteTo(&buf); err != nil {
+			t.Fatalf("error to write frame: %s", err)
+		}
+	}
+
+	for _, expected := range frames {
+		frame := &Frame{}
+		n, err := frame.ReadFrame(&buf)
+		if err != nil {
+			t.Fatalf("error to read frame: %s", err)
+		}
+		if int(n) != len(expected.WireBytes()) || !bytes.Equal(frame.WireBytes(), expected.WireBytes()) || frame.IsControl() != expected.IsControl() {
+			t.Errorf("unexpected frame: %v", frame.WireBytes())
+		}
+	}
+	if _, err := (&Frame{}).ReadFrame(&buf); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	if _, err := (&Frame{}).ReadFrame(bytes.NewReader([]byte{0, 0, 0, 4, 1})); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	// same control bound as a session
+	control := binary.BigEndian.AppendUint32([]byte{0, 0, 0, 0}, DefaultControlFrameMaxLength-3)
+	control = append(control, make([]byte, DefaultControlFrameMaxLength-3)...)
+	if _, err := (&Frame{}).ReadFrame(bytes.NewReader(control)); err != ErrFrameTooLarge {
+		t.Errorf("expected ErrFrameTooLarge, got %v", err)
+	}
+	fs := NewFstrm(bufio.NewReader(bytes.NewReader(control)), nil, nil, 0, []byte("ctype"), false)
+	if _, err := fs.RecvFrame(false); err != ErrFrameTooLarge {
+		t.Errorf("expected ErrFrameTooLarge from the session, got %v", err)
+	}
+}
+
+func TestSendFrame_ReceivedFrame(t *testing.T) {
+	// frames read from a session can be forwarded as is
+	fs_in := NewFstrm(bufio.NewReader(bytes.NewReader([]byte{0, 0, 0, 2, 1, 2})), nil, nil, 0, nil, false)
+	frame, err := fs_in.RecvFrame(false)
+	if err != nil {
+		t.Fatalf("error to read frame: %s", err)
+	}
+
+	var out bytes.Buffer
+	fs_out := NewFstrm(nil, bufio.NewWriter(&out), nil, 0, nil, false)
+	if err := fs_out.SendFrame(frame); err != nil {
+		t.Fatalf("error to send frame: %s", err)
+	}
+	if !bytes.Equal(out.Bytes(), []byte{0, 0, 0, 2, 1, 2}) {
+		t.Errorf("unexpected wire bytes: %v", out.Bytes())
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"errors"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/segmentio/kafka-go/compress"
@@ -25,9 +27,42 @@ type Fstrm struct {
 	readtimeout           time.Duration
 	ctype                 []byte
 	handshake             bool
+	autoHandshake         bool
 	dataFrameMaxLength    uint32
 	controlFrameMaxLength uint32
 	header                [4]byte
+	conformance           Conformance
+	warningHandler        func(err error)
+	recorder              *Recorder
+	sendLimiter           *rateLimiter
+	recvLimiter           *rateLimiter
+	writetimeout          time.Duration
+	spool                 *Spool
+	spoolDown             atomic.Bool
+	idletimeout           time.Duration
+	idleHandler           func(idle time.Duration) error
+	checksumEnabled       bool
+	checksum              bool
+	peerCreds             *PeerCredentials
+	session               session
+	filters               []*filterEntry
+	frameSize             frameSizeLimit
+	fragmentationEnabled  bool
+	fragmentation         bool
+	fragmentSize          uint32
+	peerMessageMax        uint32
+	messageMaxLength      uint32
+	// serializes the fragments of a message
+	fmu sync.Mutex
+	// message being reassembled
+	fragments     []byte
+	assembling    bool
+	dropFragments bool
+	ack           ackState
+	seq           sequenceState
+	auth          authState
+	// serializes writes, a frame is always written as a whole
+	wmu sync.Mutex
 }
 
 func NewFstrm(reader *bufio.Reader, writer *bufio.Writer, conn net.Conn, readtimeout time.Duration, ctype []byte, handshake bool) *Fstrm {
@@ -42,6 +77,11 @@ func NewFstrm(reader *bufio.Reader, writer *bufio.Writer, conn net.Conn, readtim
 		controlFrameMaxLength: DefaultControlFrameMaxLength,
 	}
 
+	// connection accepted by a UnixListener
+	if uconn, ok := conn.(interface{ PeerCredentials() *PeerCredentials }); ok {
+		fs.peerCreds = uconn.PeerCredentials()
+	}
+
 	return fs
 }
 
@@ -53,10 +93,81 @@ func (fs *Fstrm) SetControlFrameMaxLength(length uint32) {
 	fs.controlFrameMaxLength = length
 }
 
+// SendFrame writes a frame and flushes it, it is safe for concurrent use
+// and frames sent by several goroutines are never interleaved.
 func (fs *Fstrm) SendFrame(frame *Frame) (err error) {
+	// received frames hold the payload only
+	frame = frame.toWire()
+
+	if frame.control {
+		return fs.writeFrame(frame)
+	}
+	if fs.fragmentation {
+		return fs.sendFragmented(frame)
+	}
+	return fs.sendData(frame)
+}
+
+func (fs *Fstrm) sendData(frame *Frame) error {
+	// oversized for the receiver
+	if err := fs.checkFrameSize(frame); err != nil {
+		return err
+	}
+	// rate limit data frames
+	if fs.sendLimiter != nil && !fs.sendLimiter.wait(len(frame.data)) {
+		return ErrFrameThrottled
+	}
+	// spill to disk when the connection is down
+	if fs.spool != nil {
+		return fs.sendSpooled(frame)
+	}
+	return fs.writeFrame(frame)
+}
+
+func (fs *Fstrm) writeFrame(frame *Frame) error {
+	return fs.write(frame, 0)
+}
+
+// write sends a frame, data frames get the next sequence number
+// unless sent again with their own
+func (fs *Fstrm) write(frame *Frame, seq uint64) (err error) {
+	fs.wmu.Lock()
+	defer fs.wmu.Unlock()
+
+	// Enable write timeout
+	if fs.writetimeout != 0 && fs.conn != nil {
+		fs.conn.SetWriteDeadline(time.Now().Add(fs.writetimeout))
+		defer fs.conn.SetWriteDeadline(time.Time{})
+	}
+
+	// payload size, without checksum trailer
+	size := len(frame.data) - 4
+
+	// add sequence number
+	if fs.sequenced() && !frame.control {
+		if seq == 0 {
+			if seq, err = fs.nextSequence(frame.data[4:]); err != nil {
+				return err
+			}
+		}
+		frame = withSequence(frame, seq)
+	}
+
+	// add checksum trailer
+	if fs.checksum && !frame.control {
+		frame = appendChecksum(frame)
+	}
+
 	if _, err = fs.writer.Write(frame.data); err == nil {
 		err = fs.writer.Flush()
 	}
+	if err == nil && fs.recorder != nil {
+		fs.recorder.Record(DirectionWrite, frame.data)
+	}
+	if err == nil && !frame.control {
+		fs.session.framesSent.Add(1)
+		fs.session.bytesSent.Add(uint64(size))
+	}
 	return err
 }
 
@@ -86,16 +197,33 @@ func (fs *Fstrm) SendCompressedFrame(codec compress.Codec, frame *Frame) (err er
 }
 
 func (fs *Fstrm) readFrame(timeout bool) (*Frame, error) {
-	// Enable read timeout
-	if timeout && fs.readtimeout != 0 {
-		fs.conn.SetReadDeadline(time.Now().Add(fs.readtimeout))
-		defer fs.conn.SetDeadline(time.Time{})
+	for {
+		// frames discarded by a filter and fragments are skipped
+		frame, err := fs.readNextFrame(timeout)
+		if err != errFrameFiltered && err != errFrameFragment {
+			return frame, err
+		}
 	}
+}
 
+func (fs *Fstrm) readNextFrame(timeout bool) (*Frame, error) {
 	if fs.reader == nil {
 		return nil, ErrReaderNotReady
 	}
 
+	// wait for the next frame, the read timeout applies once it starts
+	if fs.idletimeout != 0 && fs.conn != nil {
+		if err := fs.waitIdle(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Enable read timeout
+	if timeout && fs.readtimeout != 0 {
+		fs.conn.SetReadDeadline(time.Now().Add(fs.readtimeout))
+		defer fs.conn.SetDeadline(time.Time{})
+	}
+
 	// read frame len (4 bytes)
 	if _, err := io.ReadFull(fs.reader, fs.header[:]); err != nil {
 		return nil, err
@@ -135,6 +263,13 @@ func (fs *Fstrm) readFrame(timeout bool) (*Frame, error) {
 		return nil, ErrFrameTooLarge
 	}
 
+	// filter data frames before any copy
+	if !isControl && len(fs.filters) > 0 {
+		if err := fs.filter(total); err != nil {
+			return nil, err
+		}
+	}
+
 	// allocate exact size needed
 	// avoiding pool and copy overhead
 	data := make([]byte, total)
@@ -153,6 +288,59 @@ func (fs *Fstrm) readFrame(timeout bool) (*Frame, error) {
 		data:    data,
 		control: isControl,
 	}
+	if fs.recorder != nil {
+		fs.recorder.Record(DirectionRead, wireBytes(frame))
+	}
+
+	// verify and strip checksum trailer
+	if fs.checksum && !isControl {
+		payload, err := verifyChecksum(frame.data)
+		if err != nil {
+			return nil, err
+		}
+		frame.data = payload
+	}
+
+	// strip sequence number
+	var seq uint64
+	if fs.sequenced() && !isControl {
+		if len(frame.data) < 8 {
+			return nil, ErrSequenceMissing
+		}
+		seq = binary.BigEndian.Uint64(frame.data[:8])
+		frame.data = frame.data[8:]
+		fs.trackSequence(seq)
+		if fs.ack.active {
+			fs.ackReceived(seq)
+		}
+	}
+
+	// slow down the reading of data frames
+	if fs.recvLimiter != nil && !isControl {
+		fs.recvLimiter.block(4 + total)
+	}
+
+	// reassemble fragmented messages, acknowledged from the first fragment
+	first := seq
+	if fs.fragmentation {
+		if err := fs.reassemble(frame); err != nil {
+			if err == errFrameFragment && fs.ack.fragmentSeq == 0 {
+				fs.ack.fragmentSeq = seq
+			}
+			return nil, err
+		}
+		if fs.ack.fragmentSeq != 0 && !isControl {
+			first, fs.ack.fragmentSeq = fs.ack.fragmentSeq, 0
+		}
+	}
+
+	if !isControl {
+		fs.session.framesReceived.Add(1)
+		fs.session.bytesReceived.Add(uint64(len(frame.data)))
+	}
+	if fs.ack.active && seq != 0 {
+		frame.ackFrom, frame.ackTo = first, seq
+	}
 	return frame, nil
 }
 
@@ -179,13 +367,23 @@ func (fs *Fstrm) RecvCompressedFrame(codec compress.Codec, timeout bool) (*Frame
 	uncompressedFrame := &Frame{
 		data:    make([]byte, decompressedBuffer.Len()),
 		control: frame.control,
+		ackFrom: frame.ackFrom,
+		ackTo:   frame.ackTo,
 	}
 	copy(uncompressedFrame.data, decompressedBuffer.Bytes())
 
 	return uncompressedFrame, nil
 }
 
+// ProcessFrame sends the payloads of the data frames to the channel until
+// the STOP control frame. It can't tell when a payload is handled, so it
+// returns ErrAckNotConfirmed once acknowledgements are active, use RecvFrame
+// and Ack instead.
 func (fs *Fstrm) ProcessFrame(ch chan []byte) error {
+	if fs.ack.active {
+		return ErrAckNotConfirmed
+	}
+
 	var err error
 	var frame *Frame
 	for {
@@ -197,6 +395,8 @@ func (fs *Fstrm) ProcessFrame(ch chan []byte) error {
 			if err = fs.ResetReceiver(frame); err != nil {
 				break
 			}
+			// control frame tolerated in lenient mode, nothing to deliver
+			continue
 		}
 		ch <- frame.data
 	}
@@ -204,8 +404,12 @@ func (fs *Fstrm) ProcessFrame(ch chan []byte) error {
 }
 
 func (fs *Fstrm) RecvControl() (*ControlFrame, error) {
+	return fs.recvControl(true)
+}
+
+func (fs *Fstrm) recvControl(timeout bool) (*ControlFrame, error) {
 	// waiting incoming frame
-	frame, err := fs.RecvFrame(true)
+	frame, err := fs.RecvFrame(timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -216,10 +420,14 @@ func (fs *Fstrm) RecvControl() (*ControlFrame, error) {
 	}
 
 	// decode-it
-	ctrl_frame := &ControlFrame{data: frame.data, maxLength: fs.controlFrameMaxLength}
+	ctrl_frame := &ControlFrame{data: frame.Payload(), maxLength: fs.controlFrameMaxLength}
 	if err := ctrl_frame.Decode(); err != nil {
 		return nil, err
 	}
+	if err := fs.conform(ctrl_frame); err != nil {
+		return nil, err
+	}
+	fs.recordControl(DirectionRead, ctrl_frame)
 
 	return ctrl_frame, nil
 }
@@ -235,14 +443,17 @@ func (fs *Fstrm) SendControl(control *ControlFrame) (err error) {
 	if err := fs.SendFrame(frame); err != nil {
 		return err
 	}
+	fs.recordControl(DirectionWrite, control)
 	return nil
 }
 
-func (fs Fstrm) InitSender() error {
+func (fs *Fstrm) InitSender() error {
+	var startFields []ControlField
+
 	// handshake mode enabled
 	if fs.handshake {
 		// send ready control
-		ctrl_ready := &ControlFrame{ctype: CONTROL_READY, ctypes: [][]byte{fs.ctype}}
+		ctrl_ready := &ControlFrame{ctype: CONTROL_READY, ctypes: [][]byte{fs.ctype}, fields: fs.readyFields()}
 		if err := fs.SendControl(ctrl_ready); err != nil {
 			return err
 		}
@@ -258,13 +469,27 @@ func (fs Fstrm) InitSender() error {
 		if !ctrl.CheckContentType(fs.ctype) {
 			return ErrControlFrameContentTypeUnsupported
 		}
+
+		// enable extensions acknowledged by the receiver
+		if err := fs.negotiate(ctrl); err != nil {
+			return err
+		}
+		startFields = fs.authResponse(ctrl)
+	} else {
+		startFields = fs.startFields()
 	}
 
 	// send start control frame
-	ctrl_start := &ControlFrame{ctype: CONTROL_START, ctypes: [][]byte{fs.ctype}}
+	ctrl_start := &ControlFrame{ctype: CONTROL_START, ctypes: [][]byte{fs.ctype}, fields: startFields}
 	if err := fs.SendControl(ctrl_start); err != nil {
 		return err
 	}
+	fs.startSession(fs.ctype)
+
+	// send again the frames not acknowledged
+	if fs.ack.active {
+		return fs.startAcks()
+	}
 
 	return nil
 }
@@ -279,7 +504,7 @@ func (fs *Fstrm) ResetSender() error {
 	// handshake mode enabled
 	if fs.handshake {
 		// wait finish control
-		ctrl, err := fs.RecvControl()
+		ctrl, err := fs.recvFinish(true)
 		if err != nil {
 			return err
 		}
@@ -291,14 +516,21 @@ func (fs *Fstrm) ResetSender() error {
 	return nil
 }
 
-func (fs Fstrm) InitReceiver() error {
+func (fs *Fstrm) InitReceiver() error {
+	// first control frame, READY with the bidirectional handshake
+	ctrl, err := fs.RecvControl()
+	if err != nil {
+		return err
+	}
+
+	// auto mode, the handshake is chosen by the sender
+	if fs.autoHandshake {
+		fs.handshake = ctrl.ctype == CONTROL_READY
+	}
+
 	// handshake?
 	if fs.handshake {
-		// wait ready control
-		ctrl, err := fs.RecvControl()
-		if err != nil {
-			return err
-		}
+		// check ready control
 		if ctrl.ctype != CONTROL_READY {
 			return ErrControlFrameUnexpected
 		}
@@ -306,38 +538,85 @@ func (fs Fstrm) InitReceiver() error {
 			return ErrControlFrameContentTypeUnsupported
 		}
 
+		// negotiate extensions offered by the sender
+		fields, err := fs.acceptFields(ctrl)
+		if err != nil {
+			if errors.Is(err, ErrAuthentication) {
+				return fs.authFailed(err)
+			}
+			return err
+		}
+
 		// send accept control
-		ctrl_accept := &ControlFrame{ctype: CONTROL_ACCEPT, ctypes: [][]byte{fs.ctype}}
+		ctrl_accept := &ControlFrame{ctype: CONTROL_ACCEPT, ctypes: [][]byte{fs.ctype}, fields: fields}
 		if err := fs.SendControl(ctrl_accept); err != nil {
 			return err
 		}
-	}
 
-	// decode start control frame
-	ctrl, err := fs.RecvControl()
-	if err != nil {
-		return err
+		// decode start control frame
+		ctrl, err = fs.RecvControl()
+		if err != nil {
+			return err
+		}
 	}
+
 	if ctrl.ctype != CONTROL_START {
 		return ErrControlFrameUnexpected
 	}
-	if !ctrl.CheckContentType(fs.ctype) {
-		return ErrControlFrameContentTypeUnsupported
+	if err := fs.checkStartContentType(ctrl); err != nil {
+		return err
+	}
+	if !fs.handshake {
+		fs.checksum = offersChecksum(ctrl)
+	}
+	if fs.auth.lookup != nil {
+		if err := fs.authVerify(ctrl); err != nil {
+			return fs.authFailed(err)
+		}
+	}
+
+	var ctype []byte
+	if len(ctrl.ctypes) > 0 {
+		ctype = ctrl.ctypes[0]
 	}
+	fs.startSession(ctype)
 
 	return nil
 }
 
+// ResetReceiver handles a control frame received during the data phase,
+// it returns io.EOF once the stream is stopped or nil when the frame
+// is skipped in lenient mode.
 func (fs *Fstrm) ResetReceiver(frame *Frame) error {
 	// decode stop control frame
-	ctrl := ControlFrame{data: frame.data, maxLength: fs.controlFrameMaxLength}
+	ctrl := ControlFrame{data: frame.Payload(), maxLength: fs.controlFrameMaxLength}
 	if err := ctrl.Decode(); err != nil {
+		// unknown control frame in the data phase, skip it in lenient mode
+		if fs.conformance == ConformanceLenient && errors.Is(err, ErrControlFrameUnsupported) {
+			fs.warn(err)
+			return nil
+		}
 		return err
 	}
+	if err := fs.conform(&ctrl); err != nil {
+		return err
+	}
+	fs.recordControl(DirectionRead, &ctrl)
 	if ctrl.ctype != CONTROL_STOP {
+		if fs.conformance == ConformanceLenient {
+			fs.warn(ErrControlFrameUnexpected)
+			return nil
+		}
 		return ErrControlFrameUnexpected
 	}
 
+	// acknowledge the last frames
+	if fs.ack.active {
+		if err := fs.flushAck(); err != nil {
+			return err
+		}
+	}
+
 	// bidirectional mode
 	if fs.handshake {
 		// send finish control
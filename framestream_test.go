@@ -9,12 +9,48 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/segmentio/kafka-go/compress"
 )
 
+// startPipeSession connects a sender and a receiver over net.Pipe, the option
+// funcs configure each side before its handshake. It returns the error of
+// InitReceiver, InitSender runs in the background and its error is sent on
+// the channel.
+func startPipeSession(t *testing.T, sender, receiver func(fs *Fstrm)) (*Fstrm, *Fstrm, <-chan error, error) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+
+	fs_server := NewFstrm(bufio.NewReader(server), bufio.NewWriter(server), server, 5*time.Second, []byte("ctype"), true)
+	if sender != nil {
+		sender(fs_server)
+	}
+	fs_client := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 5*time.Second, []byte("ctype"), true)
+	if receiver != nil {
+		receiver(fs_client)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- fs_server.InitSender() }()
+	return fs_server, fs_client, done, fs_client.InitReceiver()
+}
+
+// pipeSession connects a sender and a receiver over net.Pipe and waits
+// for both ends of the handshake
+func pipeSession(t *testing.T, sender, receiver func(fs *Fstrm)) (*Fstrm, *Fstrm) {
+	fs_server, fs_client, done, err := startPipeSession(t, sender, receiver)
+	if err != nil {
+		t.Fatalf("error to init framestream receiver: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("error to init framestream sender: %s", err)
+	}
+	return fs_server, fs_client
+}
+
 func TestFramestream_Handshake(t *testing.T) {
 	client, server := net.Pipe()
 	handshake := true
@@ -528,3 +564,73 @@ func TestResetReceiver_ConfigurableLimits(t *testing.T) {
 		t.Errorf("expected io.EOF with increased limit, got: %v", err)
 	}
 }
+
+func TestSendFrame_Concurrent(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	const senders = 8
+	const frames = 200
+
+	fs_server := NewFstrm(bufio.NewReader(server), bufio.NewWriter(server), server, 5*time.Second, []byte("ctype"), false)
+	go func() {
+		var wg sync.WaitGroup
+		for i := 0; i < senders; i++ {
+			wg.Add(1)
+			go func(id byte) {
+				defer wg.Done()
+				for j := 0; j < frames; j++ {
+					// payload made of the sender id, with a varying length
+					frame := &Frame{}
+					frame.Write(bytes.Repeat([]byte{id}, 1+j%64))
+					if err := fs_server.SendFrame(frame); err != nil {
+						t.Errorf("error to send frame: %s", err)
+						return
+					}
+				}
+			}(byte(i))
+		}
+		wg.Wait()
+	}()
+
+	fs_client := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 5*time.Second, []byte("ctype"), false)
+	for n := 0; n < senders*frames; n++ {
+		frame, err := fs_client.RecvFrame(true)
+		if err != nil {
+			t.Fatalf("error to receive frame %d: %s", n, err)
+		}
+		payload := frame.Data()
+		if !bytes.Equal(payload, bytes.Repeat(payload[:1], len(payload))) {
+			t.Fatalf("frame %d interleaved: %v", n, payload)
+		}
+	}
+}
+
+func BenchmarkSendFrame(b *testing.B) {
+	fs := NewFstrm(nil, bufio.NewWriter(io.Discard), nil, 0, []byte("ctype"), false)
+	frame := &Frame{}
+	frame.Write(make([]byte, 256))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := fs.SendFrame(frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSendFrame_Parallel(b *testing.B) {
+	fs := NewFstrm(nil, bufio.NewWriter(io.Discard), nil, 0, []byte("ctype"), false)
+	frame := &Frame{}
+	frame.Write(make([]byte, 256))
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := fs.SendFrame(frame); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
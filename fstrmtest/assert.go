@@ -0,0 +1,67 @@
+package fstrmtest
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	framestream "github.com/dmachard/go-framestream"
+)
+
+// Pipe returns both ends of an in-memory connection, closed at the end of the test
+func Pipe(t testing.TB) (local net.Conn, remote net.Conn) {
+	t.Helper()
+	local, remote = net.Pipe()
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	return local, remote
+}
+
+// Start runs the script on conn in the background, the returned function
+// waits for the end of the script and reports its error on t
+func Start(t testing.TB, conn net.Conn, script *Script) (wait func()) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewPeer(conn).Run(script)
+	}()
+	return func() {
+		t.Helper()
+		if err := <-done; err != nil {
+			t.Errorf("fake peer: %s", err)
+		}
+	}
+}
+
+// AssertData fails the test if the frame is not a data frame with this payload
+func AssertData(t testing.TB, frame *framestream.Frame, payload []byte) {
+	t.Helper()
+	if frame == nil {
+		t.Fatalf("expected data frame, got nil")
+	}
+	if frame.IsControl() {
+		t.Fatalf("expected data frame, got control frame")
+	}
+	if !bytes.Equal(frame.Payload(), payload) {
+		t.Fatalf("expected payload %x, got %x", payload, frame.Payload())
+	}
+}
+
+// AssertError fails the test if err does not match target
+func AssertError(t testing.TB, err error, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Fatalf("expected error %v, got %v", target, err)
+	}
+}
+
+// AssertNoError fails the test on any error
+func AssertNoError(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
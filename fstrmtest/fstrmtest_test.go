@@ -0,0 +1,102 @@
+package fstrmtest
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	framestream "github.com/dmachard/go-framestream"
+)
+
+func newFstrm(conn net.Conn, handshake bool) *framestream.Fstrm {
+	return framestream.NewFstrm(bufio.NewReader(conn), bufio.NewWriter(conn), conn, time.Second, []byte("ctype"), handshake)
+}
+
+func TestSenderScript(t *testing.T) {
+	for _, handshake := range []bool{true, false} {
+		local, remote := Pipe(t)
+		wait := Start(t, remote, SenderScript("ctype", handshake, []byte{1, 2}, []byte{3}))
+
+		fs := newFstrm(local, handshake)
+		AssertNoError(t, fs.InitReceiver())
+
+		frame, err := fs.RecvFrame(true)
+		AssertNoError(t, err)
+		AssertData(t, frame, []byte{1, 2})
+
+		frame, err = fs.RecvFrame(true)
+		AssertNoError(t, err)
+		AssertData(t, frame, []byte{3})
+
+		frame, err = fs.RecvFrame(true)
+		AssertNoError(t, err)
+		AssertError(t, fs.ResetReceiver(frame), io.EOF)
+		wait()
+	}
+}
+
+func TestReceiverScript(t *testing.T) {
+	local, remote := Pipe(t)
+	wait := Start(t, remote, ReceiverScript("ctype", true, []byte{1, 2, 3}))
+
+	fs := newFstrm(local, true)
+	AssertNoError(t, fs.InitSender())
+
+	frame := &framestream.Frame{}
+	frame.Write([]byte{1, 2, 3})
+	AssertNoError(t, fs.SendFrame(frame))
+	AssertNoError(t, fs.ResetSender())
+	wait()
+}
+
+func TestReceiverScript_Extensions(t *testing.T) {
+	local, remote := Pipe(t)
+	wait := Start(t, remote, ReceiverScript("ctype", true, []byte{1, 2, 3}))
+
+	// offered in READY, not accepted by the script
+	fs := newFstrm(local, true)
+	fs.SetChecksum(true)
+	AssertNoError(t, fs.InitSender())
+
+	frame := &framestream.Frame{}
+	frame.Write([]byte{1, 2, 3})
+	AssertNoError(t, fs.SendFrame(frame))
+	AssertNoError(t, fs.ResetSender())
+	wait()
+}
+
+func TestMalformedControl(t *testing.T) {
+	local, remote := Pipe(t)
+	wait := Start(t, remote, NewScript().SendReady("ctype").ExpectAccept("ctype").SendMalformedControl())
+
+	fs := newFstrm(local, true)
+	AssertError(t, fs.InitReceiver(), framestream.ErrControlFrameMalformed)
+	wait()
+}
+
+func TestStall(t *testing.T) {
+	local, remote := Pipe(t)
+	wait := Start(t, remote, NewScript().SendReady("ctype").ExpectAccept("ctype").Stall(200*time.Millisecond).Close())
+
+	fs := framestream.NewFstrm(bufio.NewReader(local), bufio.NewWriter(local), local, 50*time.Millisecond, []byte("ctype"), true)
+	AssertError(t, fs.InitReceiver(), os.ErrDeadlineExceeded)
+	wait()
+}
+
+func TestUnexpectedFrame(t *testing.T) {
+	local, remote := Pipe(t)
+
+	go func() {
+		fs := newFstrm(local, false)
+		fs.InitSender()
+	}()
+
+	err := NewPeer(remote).Run(NewScript().ExpectReady("ctype"))
+	if !errors.Is(err, ErrUnexpectedFrame) {
+		t.Errorf("expected ErrUnexpectedFrame, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package fstrmtest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	framestream "github.com/dmachard/go-framestream"
+)
+
+var ErrUnexpectedFrame = errors.New("unexpected frame")
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// maximum size of a frame read by the peer
+const maxFrameLength = 16 * 1024 * 1024
+
+type frame struct {
+	control bool
+	ctype   uint32
+	ctypes  []string
+	payload []byte
+}
+
+/* Fake peer playing a script on a connection */
+type Peer struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+func NewPeer(conn net.Conn) *Peer {
+	return &Peer{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: 5 * time.Second,
+	}
+}
+
+// SetTimeout sets the deadline of every read and write, zero disables it
+func (p *Peer) SetTimeout(timeout time.Duration) {
+	p.timeout = timeout
+}
+
+// Run plays the script and stops at the first failing step
+func (p *Peer) Run(script *Script) error {
+	for i, step := range script.steps {
+		if err := step.run(p); err != nil {
+			return fmt.Errorf("step %d (%s): %w", i+1, step.name, err)
+		}
+	}
+	return nil
+}
+
+func (p *Peer) deadline() time.Time {
+	if p.timeout == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(p.timeout)
+}
+
+func (p *Peer) write(data []byte) error {
+	p.conn.SetWriteDeadline(p.deadline())
+	_, err := p.conn.Write(data)
+	return err
+}
+
+func (p *Peer) read() (*frame, error) {
+	p.conn.SetReadDeadline(p.deadline())
+
+	var header [4]byte
+	if _, err := io.ReadFull(p.reader, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+
+	// data frame
+	if length != 0 {
+		if length > maxFrameLength {
+			return nil, ErrFrameTooLarge
+		}
+		f := &frame{payload: make([]byte, length)}
+		if _, err := io.ReadFull(p.reader, f.payload); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	// control frame
+	if _, err := io.ReadFull(p.reader, header[:]); err != nil {
+		return nil, err
+	}
+	length = binary.BigEndian.Uint32(header[:])
+	if length < 4 || length > maxFrameLength {
+		return nil, fmt.Errorf("%w: control frame length %d", ErrUnexpectedFrame, length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(p.reader, data); err != nil {
+		return nil, err
+	}
+
+	f := &frame{control: true, ctype: binary.BigEndian.Uint32(data[:4])}
+	fields := data[4:]
+	for len(fields) >= 8 {
+		ftype := binary.BigEndian.Uint32(fields[:4])
+		flen := binary.BigEndian.Uint32(fields[4:8])
+		if uint32(len(fields)-8) < flen {
+			break
+		}
+		// extension fields are ignored, raw or packed in a content type
+		ctype := string(fields[8 : 8+flen])
+		if ftype == framestream.CONTROL_FIELD_CONTENT_TYPE && !strings.HasPrefix(ctype, framestream.EXTENSION_CONTENT_TYPE_PREFIX) {
+			f.ctypes = append(f.ctypes, ctype)
+		}
+		fields = fields[8+flen:]
+	}
+	if len(fields) > 0 {
+		return nil, fmt.Errorf("%w: malformed control frame fields", ErrUnexpectedFrame)
+	}
+	return f, nil
+}
+
+func isClosed(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed)
+}
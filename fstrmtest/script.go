@@ -0,0 +1,229 @@
+// Package fstrmtest provides a scriptable Frame Streams peer to test
+// integrations with framestream.Fstrm without reimplementing the protocol.
+package fstrmtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	framestream "github.com/dmachard/go-framestream"
+)
+
+type step struct {
+	name string
+	run  func(p *Peer) error
+}
+
+/* Script of a fake peer, steps are played in order */
+type Script struct {
+	steps []step
+}
+
+func NewScript() *Script {
+	return &Script{}
+}
+
+func (s *Script) add(name string, run func(p *Peer) error) *Script {
+	s.steps = append(s.steps, step{name: name, run: run})
+	return s
+}
+
+// SenderScript returns the script of a well-behaved sender
+func SenderScript(ctype string, handshake bool, payloads ...[]byte) *Script {
+	s := NewScript()
+	if handshake {
+		s.SendReady(ctype).ExpectAccept(ctype)
+	}
+	s.SendStart(ctype)
+	for _, payload := range payloads {
+		s.SendData(payload)
+	}
+	s.SendStop()
+	if handshake {
+		s.ExpectFinish()
+	}
+	return s
+}
+
+// ReceiverScript returns the script of a well-behaved receiver expecting the payloads
+func ReceiverScript(ctype string, handshake bool, payloads ...[]byte) *Script {
+	s := NewScript()
+	if handshake {
+		s.ExpectReady(ctype).SendAccept(ctype)
+	}
+	s.ExpectStart(ctype)
+	for _, payload := range payloads {
+		s.ExpectData(payload)
+	}
+	s.ExpectStop()
+	if handshake {
+		s.SendFinish()
+	}
+	return s
+}
+
+// EncodeControl returns the wire bytes of a control frame
+func EncodeControl(ctype uint32, ctypes ...string) []byte {
+	cflen := 4
+	for _, ct := range ctypes {
+		cflen += 8 + len(ct)
+	}
+
+	data := make([]byte, 0, 8+cflen)
+	data = binary.BigEndian.AppendUint32(data, 0)
+	data = binary.BigEndian.AppendUint32(data, uint32(cflen))
+	data = binary.BigEndian.AppendUint32(data, ctype)
+	for _, ct := range ctypes {
+		data = binary.BigEndian.AppendUint32(data, framestream.CONTROL_FIELD_CONTENT_TYPE)
+		data = binary.BigEndian.AppendUint32(data, uint32(len(ct)))
+		data = append(data, ct...)
+	}
+	return data
+}
+
+// EncodeData returns the wire bytes of a data frame
+func EncodeData(payload []byte) []byte {
+	data := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(payload)), uint32(len(payload)))
+	return append(data, payload...)
+}
+
+func (s *Script) SendControl(ctype uint32, ctypes ...string) *Script {
+	name := fmt.Sprintf("send %s", framestream.ControlTypeName(ctype))
+	return s.add(name, func(p *Peer) error {
+		return p.write(EncodeControl(ctype, ctypes...))
+	})
+}
+
+func (s *Script) SendReady(ctypes ...string) *Script {
+	return s.SendControl(framestream.CONTROL_READY, ctypes...)
+}
+
+func (s *Script) SendAccept(ctypes ...string) *Script {
+	return s.SendControl(framestream.CONTROL_ACCEPT, ctypes...)
+}
+
+func (s *Script) SendStart(ctypes ...string) *Script {
+	return s.SendControl(framestream.CONTROL_START, ctypes...)
+}
+
+func (s *Script) SendStop() *Script {
+	return s.SendControl(framestream.CONTROL_STOP)
+}
+
+func (s *Script) SendFinish() *Script {
+	return s.SendControl(framestream.CONTROL_FINISH)
+}
+
+func (s *Script) SendData(payload []byte) *Script {
+	return s.add("send data", func(p *Peer) error {
+		return p.write(EncodeData(payload))
+	})
+}
+
+// SendRaw writes arbitrary bytes, to build any invalid frame
+func (s *Script) SendRaw(data []byte) *Script {
+	return s.add("send raw", func(p *Peer) error {
+		return p.write(data)
+	})
+}
+
+// SendMalformedControl writes a control frame whose content type field is truncated
+func (s *Script) SendMalformedControl() *Script {
+	return s.SendRaw([]byte{
+		0, 0, 0, 0, // control frame
+		0, 0, 0, 12, // control frame length
+		0, 0, 0, framestream.CONTROL_START,
+		0, 0, 0, framestream.CONTROL_FIELD_CONTENT_TYPE,
+		0, 0, 0, 10, // content type length, payload missing
+	})
+}
+
+// Stall pauses the peer, to trigger timeouts on the other side
+func (s *Script) Stall(d time.Duration) *Script {
+	return s.add(fmt.Sprintf("stall %s", d), func(p *Peer) error {
+		time.Sleep(d)
+		return nil
+	})
+}
+
+// Close closes the connection
+func (s *Script) Close() *Script {
+	return s.add("close", func(p *Peer) error {
+		return p.conn.Close()
+	})
+}
+
+func (s *Script) ExpectControl(ctype uint32, ctypes ...string) *Script {
+	name := fmt.Sprintf("expect %s", framestream.ControlTypeName(ctype))
+	return s.add(name, func(p *Peer) error {
+		frame, err := p.read()
+		if err != nil {
+			return err
+		}
+		if !frame.control {
+			return fmt.Errorf("%w: data frame instead of %s", ErrUnexpectedFrame, framestream.ControlTypeName(ctype))
+		}
+		if frame.ctype != ctype {
+			return fmt.Errorf("%w: %s instead of %s", ErrUnexpectedFrame, framestream.ControlTypeName(frame.ctype), framestream.ControlTypeName(ctype))
+		}
+		if len(frame.ctypes) != len(ctypes) {
+			return fmt.Errorf("%w: content types %q instead of %q", ErrUnexpectedFrame, frame.ctypes, ctypes)
+		}
+		for i := range ctypes {
+			if frame.ctypes[i] != ctypes[i] {
+				return fmt.Errorf("%w: content types %q instead of %q", ErrUnexpectedFrame, frame.ctypes, ctypes)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Script) ExpectReady(ctypes ...string) *Script {
+	return s.ExpectControl(framestream.CONTROL_READY, ctypes...)
+}
+
+func (s *Script) ExpectAccept(ctypes ...string) *Script {
+	return s.ExpectControl(framestream.CONTROL_ACCEPT, ctypes...)
+}
+
+func (s *Script) ExpectStart(ctypes ...string) *Script {
+	return s.ExpectControl(framestream.CONTROL_START, ctypes...)
+}
+
+func (s *Script) ExpectStop() *Script {
+	return s.ExpectControl(framestream.CONTROL_STOP)
+}
+
+func (s *Script) ExpectFinish() *Script {
+	return s.ExpectControl(framestream.CONTROL_FINISH)
+}
+
+func (s *Script) ExpectData(payload []byte) *Script {
+	return s.add("expect data", func(p *Peer) error {
+		frame, err := p.read()
+		if err != nil {
+			return err
+		}
+		if frame.control {
+			return fmt.Errorf("%w: %s instead of data", ErrUnexpectedFrame, framestream.ControlTypeName(frame.ctype))
+		}
+		if !bytes.Equal(frame.payload, payload) {
+			return fmt.Errorf("%w: payload %x instead of %x", ErrUnexpectedFrame, frame.payload, payload)
+		}
+		return nil
+	})
+}
+
+// ExpectClosed waits for the other side to close the connection
+func (s *Script) ExpectClosed() *Script {
+	return s.add("expect closed", func(p *Peer) error {
+		if _, err := p.read(); err == nil {
+			return fmt.Errorf("%w: frame received instead of close", ErrUnexpectedFrame)
+		} else if !isClosed(err) {
+			return err
+		}
+		return nil
+	})
+}
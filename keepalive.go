@@ -0,0 +1,63 @@
+package framestream
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+var ErrSessionIdle = errors.New("session idle")
+var ErrKeepAliveUnsupported = errors.New("keepalive not supported on this connection")
+
+// SetIdleTimeout sets how long the receiver waits for the start of the next
+// frame before the session is considered idle, separately from the read
+// timeout which then applies to the frame itself. Zero disables it.
+func (fs *Fstrm) SetIdleTimeout(timeout time.Duration) {
+	fs.idletimeout = timeout
+}
+
+// SetIdleHandler registers the callback invoked each time the idle timeout
+// expires, with the time spent idle so far. Returning nil keeps waiting,
+// an error aborts the read with it. Without handler, reads fail with ErrSessionIdle.
+func (fs *Fstrm) SetIdleHandler(handler func(idle time.Duration) error) {
+	fs.idleHandler = handler
+}
+
+// waitIdle blocks until the first byte of the next frame is available
+func (fs *Fstrm) waitIdle() error {
+	start := time.Now()
+	for {
+		fs.conn.SetReadDeadline(time.Now().Add(fs.idletimeout))
+		_, err := fs.reader.Peek(1)
+		if err == nil {
+			return fs.conn.SetReadDeadline(time.Time{})
+		}
+
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			return err
+		}
+		if fs.idleHandler == nil {
+			return ErrSessionIdle
+		}
+		if err := fs.idleHandler(time.Since(start)); err != nil {
+			return err
+		}
+	}
+}
+
+// SetKeepAlive configures TCP keepalive on the connection of the session,
+// so that a dead peer is detected even when no frame is exchanged
+func (fs *Fstrm) SetKeepAlive(config net.KeepAliveConfig) error {
+	conn := fs.conn
+	// unwrap TLS connections
+	if wrapper, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = wrapper.NetConn()
+	}
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return ErrKeepAliveUnsupported
+	}
+	return tcpConn.SetKeepAliveConfig(config)
+}
@@ -0,0 +1,100 @@
+package framestream
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRecvFrame_IdleHandler(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// quiet peer, one frame after a while
+	go func() {
+		time.Sleep(120 * time.Millisecond)
+		server.Write([]byte{0, 0, 0, 2, 1, 2})
+	}()
+
+	idle := 0
+	fs := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 20*time.Millisecond, []byte("ctype"), false)
+	fs.SetIdleTimeout(30 * time.Millisecond)
+	fs.SetIdleHandler(func(d time.Duration) error {
+		idle++
+		return nil
+	})
+
+	// the read timeout is shorter than the silence but only applies to the frame
+	frame, err := fs.RecvFrame(true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if frame.Len() != 2 {
+		t.Errorf("unexpected frame length: %d", frame.Len())
+	}
+	if idle == 0 {
+		t.Errorf("idle handler not called")
+	}
+}
+
+func TestRecvFrame_IdleAbort(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	fs := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 0, []byte("ctype"), false)
+	fs.SetIdleTimeout(10 * time.Millisecond)
+
+	// without handler
+	if _, err := fs.RecvFrame(false); !errors.Is(err, ErrSessionIdle) {
+		t.Errorf("expected ErrSessionIdle, got %v", err)
+	}
+
+	// handler giving up after some time
+	errDead := errors.New("peer dead")
+	fs.SetIdleHandler(func(d time.Duration) error {
+		if d > 30*time.Millisecond {
+			return errDead
+		}
+		return nil
+	})
+	if _, err := fs.RecvFrame(false); !errors.Is(err, errDead) {
+		t.Errorf("expected handler error, got %v", err)
+	}
+}
+
+func TestSetKeepAlive(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error to listen: %s", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("error to dial: %s", err)
+	}
+	defer conn.Close()
+
+	fs := NewFstrm(bufio.NewReader(conn), bufio.NewWriter(conn), conn, 0, []byte("ctype"), false)
+	if err := fs.SetKeepAlive(net.KeepAliveConfig{Enable: true, Idle: 30 * time.Second, Interval: 5 * time.Second, Count: 3}); err != nil {
+		t.Errorf("error to set keepalive: %s", err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	fs = NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 0, []byte("ctype"), false)
+	if err := fs.SetKeepAlive(net.KeepAliveConfig{Enable: true}); !errors.Is(err, ErrKeepAliveUnsupported) {
+		t.Errorf("expected ErrKeepAliveUnsupported, got %v", err)
+	}
+}
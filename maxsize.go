@@ -0,0 +1,97 @@
+package framestream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+var ErrFrameExceedsPeerLimit = errors.New("frame exceeds the maximum frame size of the peer")
+
+/* Oversized frame policy of the sender */
+type FrameSizePolicy int
+
+const (
+	// FrameSizeIgnore keeps the standard protocol, the limit is not negotiated
+	FrameSizeIgnore FrameSizePolicy = iota
+	// FrameSizeReject refuses oversized frames with a FrameSizeError
+	FrameSizeReject
+	// FrameSizeDrop discards oversized frames with a FrameSizeError,
+	// counts them and reports the error to the warning handler
+	FrameSizeDrop
+)
+
+/* Frame size error, matches ErrFrameExceedsPeerLimit */
+type FrameSizeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *FrameSizeError) Error() string {
+	return fmt.Sprintf("%s: %d bytes, limit %d", ErrFrameExceedsPeerLimit, e.Size, e.Limit)
+}
+
+func (e *FrameSizeError) Is(target error) bool {
+	return target == ErrFrameExceedsPeerLimit
+}
+
+type frameSizeLimit struct {
+	policy FrameSizePolicy
+	// limit advertised by the receiver, zero when unknown
+	peerMax uint32
+	dropped atomic.Uint64
+}
+
+// SetFrameSizePolicy offers on the sender the maximum frame size extension,
+// the receiver then advertises its data frame limit in ACCEPT and oversized
+// frames are handled locally with the policy. Receivers always advertise
+// their limit when offered. With fragmentation, oversized frames are split instead.
+func (fs *Fstrm) SetFrameSizePolicy(policy FrameSizePolicy) {
+	fs.frameSize.policy = policy
+}
+
+// PeerMaxFrameSize returns the data frame limit advertised by the receiver,
+// zero if the extension was not negotiated
+func (fs *Fstrm) PeerMaxFrameSize() uint32 {
+	return fs.frameSize.peerMax
+}
+
+// OversizedDropped returns the number of frames dropped with FrameSizeDrop
+func (fs *Fstrm) OversizedDropped() uint64 {
+	return fs.frameSize.dropped.Load()
+}
+
+// checkFrameSize applies the policy to a data frame, it returns
+// a FrameSizeError when the frame is not sent
+func (fs *Fstrm) checkFrameSize(frame *Frame) error {
+	limit := fs.frameSize.peerMax
+	if limit == 0 {
+		return nil
+	}
+
+	// frame length on the wire, extension headers and trailer included
+	size := len(frame.data) - 4 + fs.frameOverhead()
+	if size <= int(limit) {
+		return nil
+	}
+
+	err := &FrameSizeError{Size: size, Limit: int(limit)}
+	if fs.frameSize.policy == FrameSizeDrop {
+		fs.frameSize.dropped.Add(1)
+		fs.warn(err)
+	}
+	return err
+}
+
+func encodeFrameSize(length uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, length)
+}
+
+func decodeFrameSize(ctrl *ControlFrame) (uint32, bool) {
+	value, ok := ctrl.Field(CONTROL_FIELD_MAX_FRAME_SIZE)
+	if !ok || len(value) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(value), true
+}
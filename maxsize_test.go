@@ -0,0 +1,91 @@
+package framestream
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func frameSizeSession(t *testing.T, policy FrameSizePolicy, limit uint32) (*Fstrm, *Fstrm) {
+	return pipeSession(t,
+		func(fs *Fstrm) { fs.SetFrameSizePolicy(policy) },
+		func(fs *Fstrm) { fs.SetDataFrameMaxLength(limit) })
+}
+
+func TestFrameSize_Negotiation(t *testing.T) {
+	sender, _ := frameSizeSession(t, FrameSizeReject, 16)
+	if sender.PeerMaxFrameSize() != 16 {
+		t.Errorf("expected peer limit 16, got %d", sender.PeerMaxFrameSize())
+	}
+
+	// not offered, legacy behavior
+	sender, _ = frameSizeSession(t, FrameSizeIgnore, 16)
+	if sender.PeerMaxFrameSize() != 0 {
+		t.Errorf("expected no peer limit, got %d", sender.PeerMaxFrameSize())
+	}
+}
+
+func TestFrameSize_Reject(t *testing.T) {
+	sender, receiver := frameSizeSession(t, FrameSizeReject, 16)
+
+	err := sender.SendFrame(NewDataFrame(make([]byte, 17)))
+	var sizeErr *FrameSizeError
+	if !errors.Is(err, ErrFrameExceedsPeerLimit) || !errors.As(err, &sizeErr) || sizeErr.Size != 17 || sizeErr.Limit != 16 {
+		t.Fatalf("expected FrameSizeError, got %v", err)
+	}
+
+	// the session is still usable
+	go sender.SendFrame(NewDataFrame(make([]byte, 16)))
+	frame, err := receiver.RecvFrame(true)
+	if err != nil || len(frame.Payload()) != 16 {
+		t.Errorf("unexpected frame after rejection: %v", err)
+	}
+}
+
+func TestFrameSize_RejectWithSequence(t *testing.T) {
+	sender, receiver := pipeSession(t,
+		func(fs *Fstrm) {
+			fs.SetFrameSizePolicy(FrameSizeReject)
+			fs.SetSequencing(true)
+		},
+		func(fs *Fstrm) {
+			fs.SetDataFrameMaxLength(32)
+			fs.SetSequencing(true)
+		})
+
+	// 30 bytes of payload and 8 bytes of sequence number, refused before the write
+	errc := make(chan error, 1)
+	go func() { errc <- sender.SendFrame(NewDataFrame(make([]byte, 30))) }()
+	select {
+	case err := <-errc:
+		var sizeErr *FrameSizeError
+		if !errors.As(err, &sizeErr) || sizeErr.Size != 38 {
+			t.Fatalf("expected FrameSizeError of 38 bytes, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("oversized frame written to the receiver")
+	}
+
+	go sender.SendFrame(NewDataFrame(make([]byte, 24)))
+	frame, err := receiver.RecvFrame(true)
+	if err != nil {
+		t.Fatalf("error to receive frame: %s", err)
+	}
+	if len(frame.Payload()) != 24 {
+		t.Errorf("unexpected payload of %d bytes", len(frame.Payload()))
+	}
+}
+
+func TestFrameSize_Drop(t *testing.T) {
+	sender, _ := frameSizeSession(t, FrameSizeDrop, 16)
+	var warnings []error
+	sender.SetWarningHandler(func(err error) { warnings = append(warnings, err) })
+
+	var sizeErr *FrameSizeError
+	if err := sender.SendFrame(NewDataFrame(make([]byte, 32))); !errors.As(err, &sizeErr) {
+		t.Fatalf("expected FrameSizeError on drop, got %v", err)
+	}
+	if sender.OversizedDropped() != 1 || len(warnings) != 1 || !errors.Is(warnings[0], ErrFrameExceedsPeerLimit) {
+		t.Errorf("unexpected drop accounting: %d %v", sender.OversizedDropped(), warnings)
+	}
+}
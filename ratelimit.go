@@ -0,0 +1,247 @@
+package framestream
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrFrameThrottled = errors.New("frame dropped by rate limit")
+
+/* Overflow policy */
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until the frame can be handled
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop discards the frame and counts it
+	OverflowDrop
+)
+
+/*
+Rate limit, applied to data frames only.
+Sizes are counted on the wire, length prefix included.
+*/
+type RateLimit struct {
+	FramesPerSecond float64
+	BytesPerSecond  float64
+	// traffic allowed in a burst, expressed as a duration at the
+	// configured rates, one second by default
+	Burst time.Duration
+	// behavior of the sender when the limit is reached,
+	// the receiver always blocks to propagate TCP backpressure
+	Policy OverflowPolicy
+}
+
+/* Rate statistics of a session */
+type RateStats struct {
+	SendFramesPerSecond float64
+	SendBytesPerSecond  float64
+	RecvFramesPerSecond float64
+	RecvBytesPerSecond  float64
+	SendThrottled       uint64
+	SendDropped         uint64
+	RecvThrottled       uint64
+}
+
+/* Token bucket */
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst time.Duration) *tokenBucket {
+	size := rate * burst.Seconds()
+	if size < 1 {
+		size = 1
+	}
+	return &tokenBucket{rate: rate, burst: size, tokens: size, last: time.Now()}
+}
+
+func (tb *tokenBucket) refill(now time.Time) {
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+}
+
+// reserve takes n tokens, going in debt if needed,
+// and returns how long to wait before using them
+func (tb *tokenBucket) reserve(n float64) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill(time.Now())
+	tb.tokens -= n
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// available reports whether n tokens can be taken now, the lock must be held
+func (tb *tokenBucket) available(n float64) bool {
+	return tb.tokens >= n || tb.tokens >= tb.burst
+}
+
+/* Rate meter, reports the rates of the last full second */
+type rateMeter struct {
+	mu         sync.Mutex
+	second     int64
+	frames     float64
+	bytes      float64
+	lastFrames float64
+	lastBytes  float64
+	lastSecond int64
+}
+
+func (m *rateMeter) add(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(time.Now().Unix())
+	m.frames++
+	m.bytes += float64(size)
+}
+
+func (m *rateMeter) roll(now int64) {
+	if now == m.second {
+		return
+	}
+	m.lastFrames, m.lastBytes, m.lastSecond = m.frames, m.bytes, m.second
+	m.frames, m.bytes, m.second = 0, 0, now
+}
+
+func (m *rateMeter) rates() (frames float64, bytes float64) {
+	return m.ratesAt(time.Now().Unix())
+}
+
+func (m *rateMeter) ratesAt(now int64) (frames float64, bytes float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(now)
+	// no traffic during the last full second
+	if m.lastSecond != now-1 {
+		return 0, 0
+	}
+	return m.lastFrames, m.lastBytes
+}
+
+/* Rate limiter of one direction */
+type rateLimiter struct {
+	frames    *tokenBucket
+	bytes     *tokenBucket
+	policy    OverflowPolicy
+	meter     rateMeter
+	throttled atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if limit.FramesPerSecond <= 0 && limit.BytesPerSecond <= 0 {
+		return nil
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = time.Second
+	}
+
+	rl := &rateLimiter{policy: limit.Policy}
+	if limit.FramesPerSecond > 0 {
+		rl.frames = newTokenBucket(limit.FramesPerSecond, limit.Burst)
+	}
+	if limit.BytesPerSecond > 0 {
+		rl.bytes = newTokenBucket(limit.BytesPerSecond, limit.Burst)
+	}
+	return rl
+}
+
+// allow takes the tokens of a frame only when every bucket has them,
+// a frame refused by one bucket doesn't consume the other
+func (rl *rateLimiter) allow(size int) bool {
+	buckets := []*tokenBucket{rl.frames, rl.bytes}
+	needs := []float64{1, float64(size)}
+
+	now := time.Now()
+	for _, tb := range buckets {
+		if tb != nil {
+			tb.mu.Lock()
+			defer tb.mu.Unlock()
+			tb.refill(now)
+		}
+	}
+	for i, tb := range buckets {
+		if tb != nil && !tb.available(needs[i]) {
+			return false
+		}
+	}
+	for i, tb := range buckets {
+		if tb != nil {
+			tb.tokens -= needs[i]
+		}
+	}
+	return true
+}
+
+// wait applies the limit to a frame of size bytes,
+// it returns false when the frame must be dropped
+func (rl *rateLimiter) wait(size int) bool {
+	if rl.policy == OverflowDrop {
+		if !rl.allow(size) {
+			rl.dropped.Add(1)
+			return false
+		}
+		rl.meter.add(size)
+		return true
+	}
+	rl.block(size)
+	return true
+}
+
+// block waits until a frame of size bytes is allowed, whatever the policy
+func (rl *rateLimiter) block(size int) {
+	var delay time.Duration
+	if rl.frames != nil {
+		delay = rl.frames.reserve(1)
+	}
+	if rl.bytes != nil {
+		delay = max(delay, rl.bytes.reserve(float64(size)))
+	}
+	if delay > 0 {
+		rl.throttled.Add(1)
+		time.Sleep(delay)
+	}
+	rl.meter.add(size)
+}
+
+// SetSendRateLimit limits the data frames sent, a zero limit disables it
+func (fs *Fstrm) SetSendRateLimit(limit RateLimit) {
+	fs.sendLimiter = newRateLimiter(limit)
+}
+
+// SetRecvRateLimit slows down the reading of data frames,
+// so that TCP backpressure reaches the peer. A zero limit disables it.
+// Frames read are never dropped, the policy is always OverflowBlock.
+func (fs *Fstrm) SetRecvRateLimit(limit RateLimit) {
+	limit.Policy = OverflowBlock
+	fs.recvLimiter = newRateLimiter(limit)
+}
+
+func (fs *Fstrm) RateStats() RateStats {
+	var stats RateStats
+	if rl := fs.sendLimiter; rl != nil {
+		stats.SendFramesPerSecond, stats.SendBytesPerSecond = rl.meter.rates()
+		stats.SendThrottled = rl.throttled.Load()
+		stats.SendDropped = rl.dropped.Load()
+	}
+	if rl := fs.recvLimiter; rl != nil {
+		stats.RecvFramesPerSecond, stats.RecvBytesPerSecond = rl.meter.rates()
+		stats.RecvThrottled = rl.throttled.Load()
+	}
+	return stats
+}
@@ -0,0 +1,152 @@
+package framestream
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	rl := newRateLimiter(RateLimit{FramesPerSecond: 10})
+	tb := rl.frames
+
+	// full bucket
+	for i := 0; i < 10; i++ {
+		if !rl.allow(1) {
+			t.Fatalf("token %d should be available", i)
+		}
+	}
+	if rl.allow(1) {
+		t.Errorf("bucket should be empty")
+	}
+
+	// debt of one token at 10/s
+	if delay := tb.reserve(1); delay <= 0 || delay > 100*time.Millisecond {
+		t.Errorf("unexpected delay: %s", delay)
+	}
+}
+
+func TestSendRateLimit_Drop(t *testing.T) {
+	buf := new(bytes.Buffer)
+	fs := NewFstrm(nil, bufio.NewWriter(buf), nil, 0, []byte("ctype"), false)
+	fs.SetSendRateLimit(RateLimit{FramesPerSecond: 2, Policy: OverflowDrop})
+
+	// control frames are not limited
+	if err := fs.InitSender(); err != nil {
+		t.Fatalf("error to init sender: %s", err)
+	}
+
+	frame := &Frame{}
+	frame.Write([]byte{1, 2, 3, 4})
+
+	dropped := 0
+	for i := 0; i < 5; i++ {
+		err := fs.SendFrame(frame)
+		if errors.Is(err, ErrFrameThrottled) {
+			dropped++
+		} else if err != nil {
+			t.Fatalf("error to send frame: %s", err)
+		}
+	}
+	if dropped != 3 {
+		t.Errorf("expected 3 frames dropped, got %d", dropped)
+	}
+	if stats := fs.RateStats(); stats.SendDropped != 3 {
+		t.Errorf("expected 3 frames dropped in stats, got %d", stats.SendDropped)
+	}
+}
+
+func TestSendRateLimit_DropBothLimits(t *testing.T) {
+	rl := newRateLimiter(RateLimit{FramesPerSecond: 2, BytesPerSecond: 10, Policy: OverflowDrop})
+
+	if !rl.wait(8) {
+		t.Fatalf("first frame should be allowed")
+	}
+	// refused by the byte bucket, the frame budget is kept
+	if rl.wait(5) {
+		t.Fatalf("frame larger than the byte budget should be dropped")
+	}
+	if rl.frames.tokens < 1 {
+		t.Errorf("frame token consumed by a dropped frame: %f left", rl.frames.tokens)
+	}
+	if rl.dropped.Load() != 1 {
+		t.Errorf("expected 1 frame dropped, got %d", rl.dropped.Load())
+	}
+}
+
+func TestSendRateLimit_Block(t *testing.T) {
+	buf := new(bytes.Buffer)
+	fs := NewFstrm(nil, bufio.NewWriter(buf), nil, 0, []byte("ctype"), false)
+	fs.SetSendRateLimit(RateLimit{BytesPerSecond: 800, Burst: 10 * time.Millisecond})
+
+	frame := &Frame{}
+	frame.Write([]byte{1, 2, 3, 4})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := fs.SendFrame(frame); err != nil {
+			t.Fatalf("error to send frame: %s", err)
+		}
+	}
+
+	// 8 bytes burst, then 8 bytes every 10ms
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("frames not throttled, elapsed %s", elapsed)
+	}
+	if stats := fs.RateStats(); stats.SendThrottled != 2 {
+		t.Errorf("expected 2 throttled frames, got %d", stats.SendThrottled)
+	}
+	if buf.Len() != 24 {
+		t.Errorf("expected 24 bytes written, got %d", buf.Len())
+	}
+}
+
+func TestRecvRateLimit(t *testing.T) {
+	data := bytes.Repeat([]byte{0, 0, 0, 1, 9}, 4)
+	fs := NewFstrm(bufio.NewReader(bytes.NewReader(data)), nil, nil, 0, []byte("ctype"), false)
+	fs.SetRecvRateLimit(RateLimit{FramesPerSecond: 100, Burst: 10 * time.Millisecond})
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := fs.RecvFrame(false); err != nil {
+			t.Fatalf("error to receive frame: %s", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 25*time.Millisecond {
+		t.Errorf("reads not throttled, elapsed %s", elapsed)
+	}
+	if stats := fs.RateStats(); stats.RecvThrottled != 3 {
+		t.Errorf("expected 3 throttled frames, got %d", stats.RecvThrottled)
+	}
+}
+
+func TestRecvRateLimit_DropPolicyBlocks(t *testing.T) {
+	data := bytes.Repeat([]byte{0, 0, 0, 1, 9}, 4)
+	fs := NewFstrm(bufio.NewReader(bytes.NewReader(data)), nil, nil, 0, []byte("ctype"), false)
+	fs.SetRecvRateLimit(RateLimit{FramesPerSecond: 100, Burst: 10 * time.Millisecond, Policy: OverflowDrop})
+
+	for i := 0; i < 4; i++ {
+		if _, err := fs.RecvFrame(false); err != nil {
+			t.Fatalf("error to receive frame: %s", err)
+		}
+	}
+	if stats := fs.RateStats(); stats.RecvThrottled != 3 {
+		t.Errorf("expected 3 throttled frames, got %d", stats.RecvThrottled)
+	}
+}
+
+func TestRateMeter(t *testing.T) {
+	m := &rateMeter{}
+	now := time.Now().Unix()
+	m.roll(now - 1)
+	m.frames, m.bytes = 5, 50
+	m.roll(now)
+
+	frames, bytes := m.ratesAt(now)
+	if frames != 5 || bytes != 50 {
+		t.Errorf("unexpected rates: %v frames/s %v bytes/s", frames, bytes)
+	}
+}
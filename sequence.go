@@ -0,0 +1,152 @@
+package framestream
+
+import "sync"
+
+// number of missing sequences remembered to tell reordering from duplicates
+const sequenceWindow = 4096
+
+/* Sequence event type */
+type SequenceEventType int
+
+const (
+	// SequenceGap is reported when frames are missing before the sequence
+	SequenceGap SequenceEventType = iota + 1
+	// SequenceReordered is reported when a missing frame arrives late
+	SequenceReordered
+	// SequenceDuplicate is reported when a frame is received again
+	SequenceDuplicate
+)
+
+func (t SequenceEventType) String() string {
+	switch t {
+	case SequenceGap:
+		return "gap"
+	case SequenceReordered:
+		return "reordered"
+	case SequenceDuplicate:
+		return "duplicate"
+	default:
+		return "unknown"
+	}
+}
+
+/* Sequence anomaly detected by the receiver */
+type SequenceEvent struct {
+	Type     SequenceEventType
+	Sequence uint64
+	Expected uint64
+	// frames missing for a gap
+	Count uint64
+}
+
+/* Sequence counters of the receiver */
+type SequenceStats struct {
+	Received   uint64
+	Gaps       uint64
+	Missing    uint64
+	Reordered  uint64
+	Duplicates uint64
+}
+
+type sequenceState struct {
+	enabled bool
+	active  bool
+	handler func(event SequenceEvent)
+	// sender, next sequence of the session
+	next uint64
+	// receiver
+	mu       sync.Mutex
+	expected uint64
+	missing  map[uint64]struct{}
+	stats    SequenceStats
+}
+
+// SetSequencing offers (sender) or accepts (receiver) the sequencing extension,
+// each data frame then carries a sequence number of the session and the
+// receiver reports gaps, reordering and duplicates
+func (fs *Fstrm) SetSequencing(enabled bool) {
+	fs.seq.enabled = enabled
+}
+
+// SetSequenceHandler registers the callback invoked for each sequence anomaly
+func (fs *Fstrm) SetSequenceHandler(handler func(event SequenceEvent)) {
+	fs.seq.handler = handler
+}
+
+// sequenced reports whether data frames carry a sequence number
+func (fs *Fstrm) sequenced() bool {
+	return fs.ack.active || fs.seq.active
+}
+
+// nextSequence returns the sequence of the next data frame written
+func (fs *Fstrm) nextSequence(payload []byte) (uint64, error) {
+	if fs.ack.active {
+		return fs.ack.queue.push(payload)
+	}
+	fs.seq.next++
+	return fs.seq.next, nil
+}
+
+// trackSequence updates the counters with a received sequence
+func (fs *Fstrm) trackSequence(seq uint64) {
+	if !fs.seq.active {
+		return
+	}
+
+	fs.seq.mu.Lock()
+	var event *SequenceEvent
+	st := &fs.seq.stats
+	st.Received++
+	switch {
+	case fs.seq.expected == 0 || seq == fs.seq.expected:
+		fs.seq.expected = seq + 1
+
+	case seq > fs.seq.expected:
+		count := seq - fs.seq.expected
+		event = &SequenceEvent{Type: SequenceGap, Sequence: seq, Expected: fs.seq.expected, Count: count}
+		st.Gaps++
+		st.Missing += count
+		if fs.seq.missing == nil {
+			fs.seq.missing = make(map[uint64]struct{})
+		}
+		for s := max(fs.seq.expected, seq-min(count, sequenceWindow)); s < seq; s++ {
+			fs.seq.missing[s] = struct{}{}
+		}
+		fs.seq.expected = seq + 1
+		fs.evictMissing()
+
+	default:
+		if _, ok := fs.seq.missing[seq]; ok {
+			delete(fs.seq.missing, seq)
+			st.Reordered++
+			st.Missing--
+			event = &SequenceEvent{Type: SequenceReordered, Sequence: seq, Expected: fs.seq.expected}
+		} else {
+			st.Duplicates++
+			event = &SequenceEvent{Type: SequenceDuplicate, Sequence: seq, Expected: fs.seq.expected}
+		}
+	}
+	fs.seq.mu.Unlock()
+
+	if event != nil && fs.seq.handler != nil {
+		fs.seq.handler(*event)
+	}
+}
+
+// evictMissing forgets the missing sequences out of the window
+func (fs *Fstrm) evictMissing() {
+	if len(fs.seq.missing) <= sequenceWindow {
+		return
+	}
+	for s := range fs.seq.missing {
+		if s+sequenceWindow < fs.seq.expected {
+			delete(fs.seq.missing, s)
+		}
+	}
+}
+
+func (fs *Fstrm) sequenceStats() SequenceStats {
+	fs.seq.mu.Lock()
+	defer fs.seq.mu.Unlock()
+	return fs.seq.stats
+}
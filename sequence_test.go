@@ -0,0 +1,78 @@
+package framestream
+
+import (
+	"testing"
+)
+
+func sequenceSession(t *testing.T) (*Fstrm, *Fstrm) {
+	enable := func(fs *Fstrm) { fs.SetSequencing(true) }
+	return pipeSession(t, enable, enable)
+}
+
+func TestSequence_InOrder(t *testing.T) {
+	sender, receiver := sequenceSession(t)
+	if !sender.seq.active || !receiver.seq.active {
+		t.Fatalf("sequencing not negotiated")
+	}
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			sender.SendFrame(NewDataFrame([]byte{byte(i)}))
+		}
+	}()
+	payloads := recvPayloads(t, receiver, 3)
+	for i, payload := range payloads {
+		if len(payload) != 1 || payload[0] != byte(i) {
+			t.Errorf("unexpected payload %d: %v", i, payload)
+		}
+	}
+
+	stats := receiver.SessionInfo().Sequence
+	if stats != (SequenceStats{Received: 3}) {
+		t.Errorf("unexpected sequence stats: %+v", stats)
+	}
+}
+
+func TestSequence_Anomalies(t *testing.T) {
+	sender, receiver := sequenceSession(t)
+
+	var events []SequenceEvent
+	receiver.SetSequenceHandler(func(event SequenceEvent) { events = append(events, event) })
+
+	sequences := []uint64{1, 2, 5, 3, 3, 6}
+	go func() {
+		for _, seq := range sequences {
+			frame := NewDataFrame([]byte("dns"))
+			frame.toWire()
+			sender.write(frame, seq)
+		}
+	}()
+	recvPayloads(t, receiver, len(sequences))
+
+	expected := []SequenceEvent{
+		{Type: SequenceGap, Sequence: 5, Expected: 3, Count: 2},
+		{Type: SequenceReordered, Sequence: 3, Expected: 6},
+		{Type: SequenceDuplicate, Sequence: 3, Expected: 6},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("event %d: got %+v, want %+v", i, events[i], expected[i])
+		}
+	}
+
+	stats := receiver.SessionInfo().Sequence
+	want := SequenceStats{Received: 6, Gaps: 1, Missing: 1, Reordered: 1, Duplicates: 1}
+	if stats != want {
+		t.Errorf("unexpected sequence stats: %+v", stats)
+	}
+}
+
+func TestSequence_NotOffered(t *testing.T) {
+	sender, receiver := pipeSession(t, nil, func(fs *Fstrm) { fs.SetSequencing(true) })
+	if sender.seq.active || receiver.seq.active {
+		t.Errorf("sequencing active without being offered")
+	}
+}
@@ -0,0 +1,145 @@
+package framestream
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maximum number of control frames kept in the session info
+const sessionControlFramesMax = 32
+
+/* Handshake mode */
+type HandshakeMode int
+
+const (
+	HandshakeUnidirectional HandshakeMode = iota
+	HandshakeBidirectional
+	// HandshakeAuto lets the receiver follow the sender, depending
+	// on whether the first control frame is READY or START
+	HandshakeAuto
+	// HandshakeFallback lets DialFstrm reconnect unidirectionally
+	// when the bidirectional handshake fails
+	HandshakeFallback
+)
+
+func (m HandshakeMode) String() string {
+	switch m {
+	case HandshakeBidirectional:
+		return "bidirectional"
+	case HandshakeAuto:
+		return "auto"
+	case HandshakeFallback:
+		return "fallback"
+	default:
+		return "unidirectional"
+	}
+}
+
+// SetHandshakeMode overrides the handshake flag given to NewFstrm,
+// HandshakeAuto only applies to receivers and HandshakeFallback to
+// DialFstrm, both behave as bidirectional otherwise
+func (fs *Fstrm) SetHandshakeMode(mode HandshakeMode) {
+	fs.autoHandshake = mode == HandshakeAuto
+	fs.handshake = mode != HandshakeUnidirectional
+}
+
+/* Control frame exchanged during the session */
+type SessionControlFrame struct {
+	Direction Direction
+	Time      time.Time
+	Frame     *ControlFrame
+}
+
+/* Session metadata and peer identity */
+type SessionInfo struct {
+	RemoteAddr  net.Addr
+	LocalAddr   net.Addr
+	ContentType []byte
+	Handshake   HandshakeMode
+	// the bidirectional handshake failed and the sender reconnected unidirectionally
+	HandshakeFallback bool
+	StartTime         time.Time
+	FramesReceived    uint64
+	BytesReceived     uint64
+	FramesSent        uint64
+	BytesSent         uint64
+	TLS               *tls.ConnectionState
+	PeerCredentials   *PeerCredentials
+	ControlFrames     []SessionControlFrame
+	// gaps, reordering and duplicates, with the sequencing extension
+	Sequence SequenceStats
+	// key id of the sender authenticated with a pre-shared key
+	AuthKeyID string
+}
+
+type session struct {
+	mu             sync.Mutex
+	contentType    []byte
+	handshake      HandshakeMode
+	fallback       bool
+	startTime      time.Time
+	controlFrames  []SessionControlFrame
+	framesReceived atomic.Uint64
+	bytesReceived  atomic.Uint64
+	framesSent     atomic.Uint64
+	bytesSent      atomic.Uint64
+}
+
+func (fs *Fstrm) recordControl(direction Direction, ctrl *ControlFrame) {
+	// acknowledgements are not kept
+	if ctrl.ctype == CONTROL_ACK {
+		return
+	}
+	fs.session.mu.Lock()
+	defer fs.session.mu.Unlock()
+	if len(fs.session.controlFrames) < sessionControlFramesMax {
+		fs.session.controlFrames = append(fs.session.controlFrames, SessionControlFrame{Direction: direction, Time: time.Now(), Frame: ctrl})
+	}
+}
+
+// startSession records the outcome of the handshake
+func (fs *Fstrm) startSession(ctype []byte) {
+	fs.session.mu.Lock()
+	defer fs.session.mu.Unlock()
+	fs.session.contentType = ctype
+	fs.session.handshake = HandshakeUnidirectional
+	if fs.handshake {
+		fs.session.handshake = HandshakeBidirectional
+	}
+	fs.session.startTime = time.Now()
+}
+
+// SessionInfo returns a snapshot of the session metadata,
+// counters are about data frames and their payload
+func (fs *Fstrm) SessionInfo() SessionInfo {
+	info := SessionInfo{
+		Sequence:        fs.sequenceStats(),
+		AuthKeyID:       fs.auth.peer,
+		FramesReceived:  fs.session.framesReceived.Load(),
+		BytesReceived:   fs.session.bytesReceived.Load(),
+		FramesSent:      fs.session.framesSent.Load(),
+		BytesSent:       fs.session.bytesSent.Load(),
+		PeerCredentials: fs.peerCreds,
+	}
+
+	if fs.conn != nil {
+		info.RemoteAddr = fs.conn.RemoteAddr()
+		info.LocalAddr = fs.conn.LocalAddr()
+		if tlsConn, ok := fs.conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			info.TLS = &state
+		}
+	}
+
+	fs.session.mu.Lock()
+	defer fs.session.mu.Unlock()
+	info.ContentType = fs.session.contentType
+	info.Handshake = fs.session.handshake
+	info.HandshakeFallback = fs.session.fallback
+	info.StartTime = fs.session.startTime
+	info.ControlFrames = append([]SessionControlFrame{}, fs.session.controlFrames...)
+	return info
+}
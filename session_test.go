@@ -0,0 +1,158 @@
+package framestream
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSessionInfo(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	fs_server := NewFstrm(bufio.NewReader(server), bufio.NewWriter(server), server, 5*time.Second, []byte("ctype"), true)
+	done := make(chan error, 1)
+	go func() {
+		if err := fs_server.InitSender(); err != nil {
+			done <- err
+			return
+		}
+		for _, payload := range [][]byte{{1, 2, 3}, {4, 5}} {
+			frame := &Frame{}
+			frame.Write(payload)
+			if err := fs_server.SendFrame(frame); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- fs_server.ResetSender()
+	}()
+
+	fs_client := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 5*time.Second, []byte("ctype"), true)
+	if err := fs_client.InitReceiver(); err != nil {
+		t.Fatalf("error to init framestream receiver: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := fs_client.RecvFrame(false); err != nil {
+			t.Fatalf("error to receive frame: %s", err)
+		}
+	}
+	frame, err := fs_client.RecvFrame(false)
+	if err != nil {
+		t.Fatalf("error to receive stop: %s", err)
+	}
+	fs_client.ResetReceiver(frame)
+	if err := <-done; err != nil {
+		t.Fatalf("sender error: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		info   SessionInfo
+		frames uint64
+		bytes  uint64
+		dirs   []Direction
+		ctypes []uint32
+	}{
+		{
+			name: "sender", info: fs_server.SessionInfo(), frames: 2, bytes: 5,
+			dirs:   []Direction{DirectionWrite, DirectionRead, DirectionWrite, DirectionWrite, DirectionRead},
+			ctypes: []uint32{CONTROL_READY, CONTROL_ACCEPT, CONTROL_START, CONTROL_STOP, CONTROL_FINISH},
+		},
+		{
+			name: "receiver", info: fs_client.SessionInfo(), frames: 2, bytes: 5,
+			dirs:   []Direction{DirectionRead, DirectionWrite, DirectionRead, DirectionRead, DirectionWrite},
+			ctypes: []uint32{CONTROL_READY, CONTROL_ACCEPT, CONTROL_START, CONTROL_STOP, CONTROL_FINISH},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			info := tc.info
+			if !bytes.Equal(info.ContentType, []byte("ctype")) {
+				t.Errorf("unexpected content type: %q", info.ContentType)
+			}
+			if info.Handshake != HandshakeBidirectional {
+				t.Errorf("unexpected handshake mode: %s", info.Handshake)
+			}
+			if info.StartTime.IsZero() {
+				t.Errorf("start time not set")
+			}
+			if info.RemoteAddr == nil || info.LocalAddr == nil {
+				t.Errorf("addresses not set")
+			}
+			if info.TLS != nil {
+				t.Errorf("unexpected TLS state")
+			}
+			sent, received := info.FramesSent+info.FramesReceived, info.BytesSent+info.BytesReceived
+			if sent != tc.frames || received != tc.bytes {
+				t.Errorf("unexpected counters: %d frames, %d bytes", sent, received)
+			}
+			if len(info.ControlFrames) != len(tc.ctypes) {
+				t.Fatalf("unexpected control frames: %d", len(info.ControlFrames))
+			}
+			for i, cf := range info.ControlFrames {
+				if cf.Direction != tc.dirs[i] || cf.Frame.ctype != tc.ctypes[i] {
+					t.Errorf("control frame %d: unexpected %s %d", i, cf.Direction, cf.Frame.ctype)
+				}
+			}
+		})
+	}
+}
+
+func TestInitReceiver_AutoHandshake(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		handshake bool
+		mode      HandshakeMode
+		last      uint32
+	}{
+		{name: "bidirectional", handshake: true, mode: HandshakeBidirectional, last: CONTROL_FINISH},
+		{name: "unidirectional", handshake: false, mode: HandshakeUnidirectional, last: CONTROL_STOP},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			done := make(chan error, 1)
+			go func() {
+				fs_server := NewFstrm(bufio.NewReader(server), bufio.NewWriter(server), server, 5*time.Second, []byte("ctype"), tc.handshake)
+				if err := fs_server.InitSender(); err != nil {
+					done <- err
+					return
+				}
+				frame := &Frame{}
+				frame.Write([]byte{1, 2, 3})
+				if err := fs_server.SendFrame(frame); err != nil {
+					done <- err
+					return
+				}
+				done <- fs_server.ResetSender()
+			}()
+
+			fs_client := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 5*time.Second, []byte("ctype"), false)
+			fs_client.SetHandshakeMode(HandshakeAuto)
+			if err := fs_client.InitReceiver(); err != nil {
+				t.Fatalf("error to init framestream receiver: %s", err)
+			}
+			if err := fs_client.ProcessFrame(make(chan []byte, 1)); err != io.EOF {
+				t.Fatalf("unexpected end of session: %v", err)
+			}
+			if err := <-done; err != nil {
+				t.Fatalf("sender error: %s", err)
+			}
+
+			info := fs_client.SessionInfo()
+			if info.Handshake != tc.mode {
+				t.Errorf("expected %s handshake, got %s", tc.mode, info.Handshake)
+			}
+			last := info.ControlFrames[len(info.ControlFrames)-1]
+			if last.Frame.ctype != tc.last {
+				t.Errorf("unexpected last control frame: %d", last.Frame.ctype)
+			}
+		})
+	}
+}
@@ -0,0 +1,543 @@
+package framestream
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultSpoolSegmentMaxBytes = 16 * 1024 * 1024
+
+const spoolSegmentExt = ".fstrm"
+
+// sidecar file of a segment, holding the number of frames replayed
+const spoolOffsetExt = ".offset"
+
+// returned when a data frame is stored in the spool instead of being sent
+var ErrFrameSpooled = errors.New("frame spooled, not sent")
+var ErrSpoolClosed = errors.New("spool closed")
+
+/*
+Spool options
+
+Frames are stored in segment files using the Frame Streams file format
+(START, data frames, STOP), named after their creation time. The replay
+position of a segment is kept in a sidecar file, so that a replay resumes
+after a restart. Delivery is at least once: a frame delivered right before
+a crash may be replayed again.
+*/
+type SpoolOptions struct {
+	Dir         string
+	ContentType []byte
+	// segment size before rotation, DefaultSpoolSegmentMaxBytes by default
+	SegmentMaxBytes int64
+	// total size of the spool, the oldest segments are dropped above it
+	MaxBytes int64
+	// segments older than this age are dropped
+	MaxAge time.Duration
+}
+
+type spoolSegment struct {
+	id     int64
+	path   string
+	size   int64
+	frames int
+	// frames already replayed, persisted in the offset file
+	replayed int
+	removed  bool
+}
+
+func (seg *spoolSegment) created() time.Time {
+	return time.Unix(0, seg.id)
+}
+
+func (seg *spoolSegment) offsetPath() string {
+	return strings.TrimSuffix(seg.path, spoolSegmentExt) + spoolOffsetExt
+}
+
+// loadOffset reads the replay position saved before a restart
+func (seg *spoolSegment) loadOffset() {
+	data, err := os.ReadFile(seg.offsetPath())
+	if err != nil || len(data) != 8 {
+		return
+	}
+	seg.replayed = int(min(binary.BigEndian.Uint64(data), uint64(seg.frames)))
+}
+
+/* Durable disk-backed spool */
+type Spool struct {
+	mu       sync.Mutex
+	opts     SpoolOptions
+	segments []*spoolSegment
+	// segment being written, last of segments
+	current *os.File
+	writer  *Fstrm
+	size    int64
+	pending int
+	dropped uint64
+	closed  bool
+}
+
+// OpenSpool opens or creates the spool directory. Segments left by a crash
+// are truncated after their last complete frame and sealed.
+func OpenSpool(opts SpoolOptions) (*Spool, error) {
+	if opts.SegmentMaxBytes <= 0 {
+		opts.SegmentMaxBytes = DefaultSpoolSegmentMaxBytes
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &Spool{opts: opts}
+	for _, entry := range entries {
+		name := entry.Name()
+		// offset file left by a crash during the removal of its segment
+		if strings.HasSuffix(name, spoolOffsetExt) {
+			segment := strings.TrimSuffix(name, spoolOffsetExt) + spoolSegmentExt
+			if _, err := os.Stat(filepath.Join(opts.Dir, segment)); errors.Is(err, os.ErrNotExist) {
+				os.Remove(filepath.Join(opts.Dir, name))
+			}
+			continue
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(name, spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seg := &spoolSegment{id: id, path: filepath.Join(opts.Dir, name)}
+		if err := recoverSegment(seg); err != nil {
+			return nil, err
+		}
+		seg.loadOffset()
+		if seg.frames == seg.replayed {
+			os.Remove(seg.path)
+			os.Remove(seg.offsetPath())
+			continue
+		}
+		sp.segments = append(sp.segments, seg)
+		sp.size += seg.size
+		sp.pending += seg.frames - seg.replayed
+	}
+	sort.Slice(sp.segments, func(i, j int) bool { return sp.segments[i].id < sp.segments[j].id })
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.enforceLimits()
+	return sp, nil
+}
+
+// recoverSegment counts the frames of a segment, truncates a partial
+// trailing frame and appends the STOP control frame if missing
+func recoverSegment(seg *spoolSegment) error {
+	f, err := os.OpenFile(seg.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var header [4]byte
+	var offset int64
+	sealed := false
+	for !sealed {
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(header[:]))
+
+		// data frame
+		if length != 0 {
+			if n, _ := io.CopyN(io.Discard, reader, length); n != length {
+				break
+			}
+			offset += 4 + length
+			seg.frames++
+			continue
+		}
+
+		// control frame, a corrupt length is handled as a truncated tail
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			break
+		}
+		length = int64(binary.BigEndian.Uint32(header[:]))
+		if length > DefaultControlFrameMaxLength {
+			break
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			break
+		}
+		offset += 8 + length
+		sealed = length >= 4 && binary.BigEndian.Uint32(body[:4]) == CONTROL_STOP
+	}
+
+	if !sealed {
+		if err := f.Truncate(offset); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(stopFrameBytes(), offset); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		offset += int64(len(stopFrameBytes()))
+	}
+	seg.size = offset
+	return nil
+}
+
+func stopFrameBytes() []byte {
+	ctrl := &ControlFrame{ctype: CONTROL_STOP}
+	ctrl.Encode()
+	frame := &Frame{control: true}
+	frame.Write(ctrl.data)
+	return frame.data
+}
+
+// Append stores a payload at the end of the spool
+func (sp *Spool) Append(payload []byte) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.append(payload)
+}
+
+// appendIfPending stores the payload only if the spool is not empty,
+// so that frames are never sent before older spooled ones
+func (sp *Spool) appendIfPending(payload []byte) (bool, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.pending == 0 {
+		return false, nil
+	}
+	return true, sp.append(payload)
+}
+
+func (sp *Spool) append(payload []byte) error {
+	if sp.closed {
+		return ErrSpoolClosed
+	}
+
+	if sp.current == nil {
+		if err := sp.openSegment(); err != nil {
+			return err
+		}
+	}
+
+	frame := &Frame{}
+	if err := frame.Write(payload); err != nil {
+		return err
+	}
+	if err := sp.writer.SendFrame(frame); err != nil {
+		return err
+	}
+
+	seg := sp.segments[len(sp.segments)-1]
+	seg.size += int64(frame.Len())
+	seg.frames++
+	sp.size += int64(frame.Len())
+	sp.pending++
+
+	if seg.size >= sp.opts.SegmentMaxBytes {
+		if err := sp.seal(); err != nil {
+			return err
+		}
+	}
+	sp.enforceLimits()
+	return nil
+}
+
+func (sp *Spool) openSegment() error {
+	id := time.Now().UnixNano()
+	if n := len(sp.segments); n > 0 && id <= sp.segments[n-1].id {
+		id = sp.segments[n-1].id + 1
+	}
+
+	path := filepath.Join(sp.opts.Dir, fmt.Sprintf("%020d%s", id, spoolSegmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	writer := NewFstrm(nil, bufio.NewWriter(f), nil, 0, sp.opts.ContentType, false)
+	if err := writer.InitSender(); err != nil {
+		f.Close()
+		return err
+	}
+
+	start := &ControlFrame{ctype: CONTROL_START, ctypes: [][]byte{sp.opts.ContentType}}
+	start.Encode()
+
+	sp.current, sp.writer = f, writer
+	sp.segments = append(sp.segments, &spoolSegment{id: id, path: path, size: int64(4 + len(start.data))})
+	sp.size += int64(4 + len(start.data))
+	return nil
+}
+
+// seal ends the current segment with a STOP control frame
+func (sp *Spool) seal() error {
+	if sp.current == nil {
+		return nil
+	}
+
+	err := sp.writer.ResetSender()
+	if err == nil {
+		err = sp.current.Sync()
+	}
+	if cerr := sp.current.Close(); err == nil {
+		err = cerr
+	}
+	sp.current, sp.writer = nil, nil
+
+	seg := sp.segments[len(sp.segments)-1]
+	seg.size += int64(len(stopFrameBytes()))
+	sp.size += int64(len(stopFrameBytes()))
+	return err
+}
+
+// enforceLimits drops the oldest segments above the size and age caps
+func (sp *Spool) enforceLimits() {
+	for len(sp.segments) > 0 {
+		oldest := sp.segments[0]
+		tooBig := sp.opts.MaxBytes > 0 && sp.size > sp.opts.MaxBytes
+		tooOld := sp.opts.MaxAge > 0 && time.Since(oldest.created()) > sp.opts.MaxAge
+		if !tooBig && !tooOld {
+			return
+		}
+		if sp.current != nil && len(sp.segments) == 1 {
+			sp.seal()
+		}
+		sp.removeOldest()
+		sp.dropped += uint64(oldest.frames - oldest.replayed)
+	}
+}
+
+func (sp *Spool) removeOldest() {
+	oldest := sp.segments[0]
+	os.Remove(oldest.path)
+	os.Remove(oldest.offsetPath())
+	oldest.removed = true
+	sp.segments = sp.segments[1:]
+	sp.size -= oldest.size
+	sp.pending -= oldest.frames - oldest.replayed
+}
+
+// Replay calls fn in order for every spooled payload and removes the segments
+// fully delivered. It stops at the first error, the next replay resumes after
+// the last delivered frame, also after a restart.
+func (sp *Spool) Replay(fn func(payload []byte) error) error {
+	for {
+		sp.mu.Lock()
+		if sp.closed {
+			sp.mu.Unlock()
+			return ErrSpoolClosed
+		}
+		sp.enforceLimits()
+		if len(sp.segments) == 0 {
+			sp.mu.Unlock()
+			return nil
+		}
+		// the segment being written is sealed before its replay
+		if sp.current != nil && len(sp.segments) == 1 {
+			if err := sp.seal(); err != nil {
+				sp.mu.Unlock()
+				return err
+			}
+		}
+		seg := sp.segments[0]
+		sp.mu.Unlock()
+
+		if err := sp.replaySegment(seg, fn); err != nil {
+			return err
+		}
+
+		sp.mu.Lock()
+		if len(sp.segments) > 0 && sp.segments[0] == seg {
+			sp.removeOldest()
+		}
+		sp.mu.Unlock()
+	}
+}
+
+func (sp *Spool) replaySegment(seg *spoolSegment, fn func(payload []byte) error) error {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := NewFstrm(bufio.NewReader(f), nil, nil, 0, sp.opts.ContentType, false)
+	reader.SetDataFrameMaxLength(^uint32(0))
+
+	offset, err := os.OpenFile(seg.offsetPath(), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer offset.Close()
+
+	sp.mu.Lock()
+	skip := seg.replayed
+	sp.mu.Unlock()
+
+	index := 0
+	for {
+		frame, err := reader.RecvFrame(false)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if frame.control {
+			continue
+		}
+
+		index++
+		if index <= skip {
+			continue
+		}
+		if err := fn(frame.data); err != nil {
+			return err
+		}
+
+		sp.mu.Lock()
+		// segment dropped by the caps during its replay
+		if seg.removed {
+			sp.mu.Unlock()
+			return nil
+		}
+		seg.replayed++
+		sp.pending--
+		replayed := seg.replayed
+		sp.mu.Unlock()
+
+		if _, err := offset.WriteAt(binary.BigEndian.AppendUint64(nil, uint64(replayed)), 0); err != nil {
+			return err
+		}
+	}
+}
+
+// Pending returns the number of frames waiting in the spool
+func (sp *Spool) Pending() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.pending
+}
+
+// Size returns the size of the spool on disk
+func (sp *Spool) Size() int64 {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.size
+}
+
+// Dropped returns the number of frames dropped by the size and age caps
+func (sp *Spool) Dropped() uint64 {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.dropped
+}
+
+// Close seals the current segment, spooled frames are kept for the next open
+func (sp *Spool) Close() error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.closed {
+		return nil
+	}
+	sp.closed = true
+	return sp.seal()
+}
+
+// SetSpool spills data frames to the spool when the connection is down
+// or the write timeout expires, see ReplaySpool to deliver them
+func (fs *Fstrm) SetSpool(spool *Spool) {
+	fs.spool = spool
+}
+
+// SetWriteTimeout bounds the time spent writing a frame, zero disables it
+func (fs *Fstrm) SetWriteTimeout(timeout time.Duration) {
+	fs.writetimeout = timeout
+}
+
+// sendSpooled sends a data frame or stores it in the spool, frames are
+// spooled while older ones are pending and once the connection is down,
+// until the next successful replay. ErrFrameSpooled is returned for every
+// frame stored instead of being sent. A frame held by the acknowledgement
+// queue when its write fails is not spooled, the queue sends it again.
+func (fs *Fstrm) sendSpooled(frame *Frame) error {
+	payload := frame.data[4:]
+	if fs.spoolDown.Load() {
+		if err := fs.spool.Append(payload); err != nil {
+			return err
+		}
+		return ErrFrameSpooled
+	}
+
+	spooled, err := fs.spool.appendIfPending(payload)
+	if err != nil {
+		return err
+	}
+	if spooled {
+		return ErrFrameSpooled
+	}
+
+	if err := fs.writeFrame(frame); err != nil {
+		fs.spoolDown.Store(true)
+		if fs.ack.active && !errors.Is(err, ErrAckQueueFull) {
+			return ErrFrameSpooled
+		}
+		if err := fs.spool.Append(payload); err != nil {
+			return err
+		}
+		return ErrFrameSpooled
+	}
+	return nil
+}
+
+// ReplaySpool sends in order all the frames stored in the spool,
+// to call after InitSender on a new connection
+func (fs *Fstrm) ReplaySpool() error {
+	return fs.replaySpool(context.Background())
+}
+
+func (fs *Fstrm) replaySpool(ctx context.Context) error {
+	if fs.spool == nil {
+		return nil
+	}
+	err := fs.spool.Replay(func(payload []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		frame := &Frame{}
+		if err := frame.Write(payload); err != nil {
+			return err
+		}
+		// spooled frames are throttled, never dropped
+		if fs.sendLimiter != nil {
+			fs.sendLimiter.block(len(frame.data))
+		}
+		return fs.writeFrame(frame)
+	})
+	// sending again once all the spooled frames are delivered
+	fs.spoolDown.Store(err != nil)
+	return err
+}
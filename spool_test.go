@@ -0,0 +1,394 @@
+package framestream
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func replayAll(t *testing.T, sp *Spool) [][]byte {
+	var payloads [][]byte
+	err := sp.Replay(func(payload []byte) error {
+		payloads = append(payloads, append([]byte{}, payload...))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error to replay spool: %s", err)
+	}
+	return payloads
+}
+
+func TestSpool_AppendReplay(t *testing.T) {
+	sp, err := OpenSpool(SpoolOptions{Dir: t.TempDir(), ContentType: []byte("ctype"), SegmentMaxBytes: 64})
+	if err != nil {
+		t.Fatalf("error to open spool: %s", err)
+	}
+	defer sp.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := sp.Append(bytes.Repeat([]byte{byte(i)}, 10)); err != nil {
+			t.Fatalf("error to append: %s", err)
+		}
+	}
+	if len(sp.segments) < 2 {
+		t.Errorf("expected segment rotation, got %d segments", len(sp.segments))
+	}
+	if sp.Pending() != 10 {
+		t.Errorf("expected 10 pending frames, got %d", sp.Pending())
+	}
+
+	payloads := replayAll(t, sp)
+	if len(payloads) != 10 {
+		t.Fatalf("expected 10 frames, got %d", len(payloads))
+	}
+	for i, payload := range payloads {
+		if payload[0] != byte(i) {
+			t.Errorf("frame %d out of order", i)
+		}
+	}
+	if sp.Pending() != 0 || sp.Size() != 0 {
+		t.Errorf("spool not empty after replay: %d frames, %d bytes", sp.Pending(), sp.Size())
+	}
+}
+
+func TestSpool_ReplayResume(t *testing.T) {
+	sp, err := OpenSpool(SpoolOptions{Dir: t.TempDir(), ContentType: []byte("ctype")})
+	if err != nil {
+		t.Fatalf("error to open spool: %s", err)
+	}
+	defer sp.Close()
+
+	for i := 0; i < 4; i++ {
+		sp.Append([]byte{byte(i)})
+	}
+
+	// delivery fails on the third frame
+	delivered := 0
+	errDown := errors.New("down")
+	err = sp.Replay(func(payload []byte) error {
+		if delivered == 2 {
+			return errDown
+		}
+		delivered++
+		return nil
+	})
+	if !errors.Is(err, errDown) {
+		t.Fatalf("expected replay error, got %v", err)
+	}
+
+	payloads := replayAll(t, sp)
+	if len(payloads) != 2 || payloads[0][0] != 2 || payloads[1][0] != 3 {
+		t.Errorf("unexpected frames after resume: %v", payloads)
+	}
+}
+
+func TestSpool_CrashRecovery(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := OpenSpool(SpoolOptions{Dir: dir, ContentType: []byte("ctype")})
+	if err != nil {
+		t.Fatalf("error to open spool: %s", err)
+	}
+	sp.Append([]byte{1, 2, 3})
+	sp.Append([]byte{4, 5, 6})
+
+	// simulate a crash in the middle of a frame, segment left unsealed
+	path := sp.segments[0].path
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("error to open segment: %s", err)
+	}
+	f.Write([]byte{0, 0, 0, 10, 1, 2})
+	f.Close()
+
+	sp, err = OpenSpool(SpoolOptions{Dir: dir, ContentType: []byte("ctype")})
+	if err != nil {
+		t.Fatalf("error to reopen spool: %s", err)
+	}
+	defer sp.Close()
+
+	if sp.Pending() != 2 {
+		t.Errorf("expected 2 recovered frames, got %d", sp.Pending())
+	}
+	payloads := replayAll(t, sp)
+	if len(payloads) != 2 || !bytes.Equal(payloads[1], []byte{4, 5, 6}) {
+		t.Errorf("unexpected recovered frames: %v", payloads)
+	}
+}
+
+func TestSpool_ReplayResumeAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := OpenSpool(SpoolOptions{Dir: dir, ContentType: []byte("ctype")})
+	if err != nil {
+		t.Fatalf("error to open spool: %s", err)
+	}
+	for i := 0; i < 4; i++ {
+		sp.Append([]byte{byte(i)})
+	}
+
+	// two frames delivered before the crash
+	errDown := errors.New("down")
+	delivered := 0
+	sp.Replay(func(payload []byte) error {
+		if delivered == 2 {
+			return errDown
+		}
+		delivered++
+		return nil
+	})
+	sp.Close()
+
+	sp, err = OpenSpool(SpoolOptions{Dir: dir, ContentType: []byte("ctype")})
+	if err != nil {
+		t.Fatalf("error to reopen spool: %s", err)
+	}
+	defer sp.Close()
+
+	if sp.Pending() != 2 {
+		t.Errorf("expected 2 pending frames, got %d", sp.Pending())
+	}
+	payloads := replayAll(t, sp)
+	if len(payloads) != 2 || payloads[0][0] != 2 || payloads[1][0] != 3 {
+		t.Errorf("unexpected frames after restart: %v", payloads)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("spool directory not empty after replay: %d files", len(entries))
+	}
+}
+
+func TestSpool_CorruptControlLength(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := OpenSpool(SpoolOptions{Dir: dir, ContentType: []byte("ctype")})
+	if err != nil {
+		t.Fatalf("error to open spool: %s", err)
+	}
+	sp.Append([]byte{1, 2, 3})
+
+	// escape followed by a huge control frame length
+	f, err := os.OpenFile(sp.segments[0].path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("error to open segment: %s", err)
+	}
+	f.Write([]byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff})
+	f.Close()
+
+	sp, err = OpenSpool(SpoolOptions{Dir: dir, ContentType: []byte("ctype")})
+	if err != nil {
+		t.Fatalf("error to reopen spool: %s", err)
+	}
+	defer sp.Close()
+
+	payloads := replayAll(t, sp)
+	if len(payloads) != 1 || !bytes.Equal(payloads[0], []byte{1, 2, 3}) {
+		t.Errorf("unexpected recovered frames: %v", payloads)
+	}
+}
+
+func TestSpool_Caps(t *testing.T) {
+	sp, err := OpenSpool(SpoolOptions{Dir: t.TempDir(), ContentType: []byte("ctype"), SegmentMaxBytes: 32, MaxBytes: 100})
+	if err != nil {
+		t.Fatalf("error to open spool: %s", err)
+	}
+	defer sp.Close()
+
+	for i := 0; i < 20; i++ {
+		sp.Append(bytes.Repeat([]byte{byte(i)}, 10))
+	}
+	if sp.Size() > 100 {
+		t.Errorf("spool size over the cap: %d", sp.Size())
+	}
+	if sp.Dropped() == 0 || int(sp.Dropped())+sp.Pending() != 20 {
+		t.Errorf("unexpected counters: %d dropped, %d pending", sp.Dropped(), sp.Pending())
+	}
+
+	// the most recent frames are kept
+	payloads := replayAll(t, sp)
+	if last := payloads[len(payloads)-1]; last[0] != 19 {
+		t.Errorf("unexpected last frame %v", last)
+	}
+
+	// age cap
+	sp.opts.MaxAge = time.Millisecond
+	sp.Append([]byte{1})
+	time.Sleep(5 * time.Millisecond)
+	if payloads := replayAll(t, sp); len(payloads) != 0 {
+		t.Errorf("expected old frames to be dropped, got %d", len(payloads))
+	}
+}
+
+func TestFramestream_SpoolOutage(t *testing.T) {
+	sp, err := OpenSpool(SpoolOptions{Dir: filepath.Join(t.TempDir(), "spool"), ContentType: []byte("ctype")})
+	if err != nil {
+		t.Fatalf("error to open spool: %s", err)
+	}
+	defer sp.Close()
+
+	frame := func(b byte) *Frame {
+		f := &Frame{}
+		f.Write([]byte{b})
+		return f
+	}
+
+	// collector down
+	client, server := net.Pipe()
+	server.Close()
+	fs := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, time.Second, []byte("ctype"), false)
+	fs.SetSpool(sp)
+	for i := 0; i < 3; i++ {
+		if err := fs.SendFrame(frame(byte(i))); !errors.Is(err, ErrFrameSpooled) {
+			t.Fatalf("expected ErrFrameSpooled, got %v", err)
+		}
+	}
+
+	// reconnect and replay
+	client, server = net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	received := make(chan []byte, 10)
+	go func() {
+		rx := NewFstrm(bufio.NewReader(server), bufio.NewWriter(server), server, time.Second, []byte("ctype"), false)
+		if err := rx.InitReceiver(); err != nil {
+			t.Errorf("error to init receiver: %s", err)
+			return
+		}
+		rx.ProcessFrame(received)
+		close(received)
+	}()
+
+	fs = NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, time.Second, []byte("ctype"), false)
+	fs.SetSpool(sp)
+	if err := fs.InitSender(); err != nil {
+		t.Fatalf("error to init sender: %s", err)
+	}
+	if err := fs.ReplaySpool(); err != nil {
+		t.Fatalf("error to replay spool: %s", err)
+	}
+	if err := fs.SendFrame(frame(3)); err != nil {
+		t.Fatalf("error to send frame: %s", err)
+	}
+	if err := fs.ResetSender(); err != nil {
+		t.Fatalf("error to reset sender: %s", err)
+	}
+
+	var got []byte
+	for payload := range received {
+		got = append(got, payload...)
+	}
+	if !bytes.Equal(got, []byte{0, 1, 2, 3}) {
+		t.Errorf("unexpected frames order: %v", got)
+	}
+}
+
+func TestFramestream_SpoolAfterReplay(t *testing.T) {
+	sp, err := OpenSpool(SpoolOptions{Dir: t.TempDir(), ContentType: []byte("ctype")})
+	if err != nil {
+		t.Fatalf("error to open spool: %s", err)
+	}
+	defer sp.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	received := make(chan []byte, 10)
+	go func() {
+		rx := NewFstrm(bufio.NewReader(server), bufio.NewWriter(server), server, time.Second, []byte("ctype"), false)
+		if err := rx.InitReceiver(); err != nil {
+			t.Errorf("error to init receiver: %s", err)
+			return
+		}
+		rx.ProcessFrame(received)
+		close(received)
+	}()
+
+	fs := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, time.Second, []byte("ctype"), false)
+	fs.SetSpool(sp)
+	if err := fs.InitSender(); err != nil {
+		t.Fatalf("error to init sender: %s", err)
+	}
+
+	// write timeout expired, the frames are spooled until the replay
+	fs.spoolDown.Store(true)
+	for i := 0; i < 2; i++ {
+		if err := fs.SendFrame(NewDataFrame([]byte{byte(i)})); !errors.Is(err, ErrFrameSpooled) {
+			t.Fatalf("expected ErrFrameSpooled, got %v", err)
+		}
+	}
+	if err := fs.ReplaySpool(); err != nil {
+		t.Fatalf("error to replay spool: %s", err)
+	}
+	if err := fs.SendFrame(NewDataFrame([]byte{2})); err != nil {
+		t.Fatalf("expected frame sent after the replay, got %v", err)
+	}
+	if err := fs.ResetSender(); err != nil {
+		t.Fatalf("error to reset sender: %s", err)
+	}
+
+	var got []byte
+	for payload := range received {
+		got = append(got, payload...)
+	}
+	if !bytes.Equal(got, []byte{0, 1, 2}) {
+		t.Errorf("unexpected frames order: %v", got)
+	}
+}
+
+func TestFramestream_SpoolPending(t *testing.T) {
+	sp, err := OpenSpool(SpoolOptions{Dir: t.TempDir(), ContentType: []byte("ctype")})
+	if err != nil {
+		t.Fatalf("error to open spool: %s", err)
+	}
+	defer sp.Close()
+	sp.Append([]byte{0})
+
+	buf := new(bytes.Buffer)
+	fs := NewFstrm(nil, bufio.NewWriter(buf), nil, 0, []byte("ctype"), false)
+	fs.SetSpool(sp)
+
+	// stored behind the older spooled frame, not sent
+	if err := fs.SendFrame(NewDataFrame([]byte{1})); !errors.Is(err, ErrFrameSpooled) {
+		t.Errorf("expected ErrFrameSpooled, got %v", err)
+	}
+	if sp.Pending() != 2 {
+		t.Errorf("expected 2 pending frames, got %d", sp.Pending())
+	}
+}
+
+func TestFramestream_SpoolAckQueue(t *testing.T) {
+	sp, err := OpenSpool(SpoolOptions{Dir: t.TempDir(), ContentType: []byte("ctype")})
+	if err != nil {
+		t.Fatalf("error to open spool: %s", err)
+	}
+	defer sp.Close()
+
+	// collector down, acknowledgements negotiated
+	client, server := net.Pipe()
+	server.Close()
+	fs := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, time.Second, []byte("ctype"), false)
+	fs.SetSpool(sp)
+	queue := NewAckQueue(1)
+	fs.SetAckQueue(queue)
+	fs.ack.active = true
+
+	// held by the queue, not spooled twice
+	if err := fs.SendFrame(NewDataFrame([]byte{0})); !errors.Is(err, ErrFrameSpooled) {
+		t.Fatalf("expected ErrFrameSpooled, got %v", err)
+	}
+	if queue.Pending() != 1 || sp.Pending() != 0 {
+		t.Errorf("expected the frame in the queue only, got %d queued and %d spooled", queue.Pending(), sp.Pending())
+	}
+
+	// the next frames follow in the spool
+	fs.spoolDown.Store(false)
+	if err := fs.SendFrame(NewDataFrame([]byte{1})); !errors.Is(err, ErrFrameSpooled) {
+		t.Fatalf("expected ErrFrameSpooled, got %v", err)
+	}
+	if queue.Pending() != 1 || sp.Pending() != 1 {
+		t.Errorf("expected the full queue to spool, got %d queued and %d spooled", queue.Pending(), sp.Pending())
+	}
+}
@@ -0,0 +1,247 @@
+package framestream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrTraceMalformed = errors.New("trace file malformed")
+var ErrTraceMismatch = errors.New("frame does not match the trace")
+
+var traceMagic = []byte("FSTRMTRC")
+
+const traceVersion = 1
+
+// bound of a record read from a trace, a larger length is a corrupt file
+const traceRecordMaxLength = DefaultMessageMaxLength
+
+/* Frame direction, seen from the recorded Fstrm */
+type Direction uint8
+
+const (
+	DirectionRead  Direction = 1
+	DirectionWrite Direction = 2
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionRead:
+		return "read"
+	case DirectionWrite:
+		return "write"
+	default:
+		return "unknown"
+	}
+}
+
+/*
+Trace file
+
+|------------------------------------|----------------------|
+| Magic "FSTRMTRC"                   | 8 bytes              |
+|------------------------------------|----------------------|
+| Version                            | 1 byte               |
+|------------------------------------|----------------------|
+
+followed by one record per frame
+
+|------------------------------------|----------------------|
+| Direction                          | 1 byte               |
+|------------------------------------|----------------------|
+| Timestamp (unix nanoseconds)       | 8 bytes              |
+|------------------------------------|----------------------|
+| Frame length                       | 4 bytes              |
+|------------------------------------|----------------------|
+| Frame (wire bytes)                 | xx bytes             |
+|------------------------------------|----------------------|
+*/
+type TraceRecord struct {
+	Direction Direction
+	Time      time.Time
+	Frame     []byte
+}
+
+/* Recorder */
+type Recorder struct {
+	mu     sync.Mutex
+	writer io.Writer
+	err    error
+}
+
+// NewRecorder writes the trace header and returns a recorder
+// appending every frame read or written by a Fstrm to w.
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	header := append([]byte{}, traceMagic...)
+	header = append(header, traceVersion)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Recorder{writer: w}, nil
+}
+
+func (rec *Recorder) Record(direction Direction, wire []byte) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.err != nil {
+		return rec.err
+	}
+
+	// build the record in one buffer to keep a single write per frame
+	data := make([]byte, 13+len(wire))
+	data[0] = byte(direction)
+	binary.BigEndian.PutUint64(data[1:9], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(data[9:13], uint32(len(wire)))
+	copy(data[13:], wire)
+
+	_, rec.err = rec.writer.Write(data)
+	return rec.err
+}
+
+// Err returns the first write error, recording stops after it
+func (rec *Recorder) Err() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.err
+}
+
+func (fs *Fstrm) SetRecorder(rec *Recorder) {
+	fs.recorder = rec
+}
+
+// wireBytes returns the frame as sent on the wire
+func wireBytes(frame *Frame) []byte {
+	wire := make([]byte, 4+len(frame.data))
+	if !frame.control {
+		binary.BigEndian.PutUint32(wire[:4], uint32(len(frame.data)))
+	}
+	copy(wire[4:], frame.data)
+	return wire
+}
+
+/* Trace reader */
+type TraceReader struct {
+	reader *bufio.Reader
+}
+
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	reader := bufio.NewReader(r)
+
+	header := make([]byte, len(traceMagic)+1)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, ErrTraceMalformed
+	}
+	if !bytes.Equal(header[:len(traceMagic)], traceMagic) || header[len(traceMagic)] != traceVersion {
+		return nil, ErrTraceMalformed
+	}
+	return &TraceReader{reader: reader}, nil
+}
+
+// Next returns the next record or io.EOF at the end of the trace
+func (tr *TraceReader) Next() (*TraceRecord, error) {
+	var header [13]byte
+	if _, err := io.ReadFull(tr.reader, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, ErrTraceMalformed
+	}
+
+	length := binary.BigEndian.Uint32(header[9:13])
+	if length > traceRecordMaxLength {
+		return nil, ErrTraceMalformed
+	}
+
+	record := &TraceRecord{
+		Direction: Direction(header[0]),
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(header[1:9]))),
+		Frame:     make([]byte, length),
+	}
+	if _, err := io.ReadFull(tr.reader, record.Frame); err != nil {
+		return nil, ErrTraceMalformed
+	}
+	return record, nil
+}
+
+/* Replayer, drives a fake peer from a trace */
+type Replayer struct {
+	records     []*TraceRecord
+	readtimeout time.Duration
+	realtime    bool
+}
+
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	tr, err := NewTraceReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	replayer := &Replayer{readtimeout: 5 * time.Second}
+	for {
+		record, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		replayer.records = append(replayer.records, record)
+	}
+	return replayer, nil
+}
+
+func (rp *Replayer) Records() []*TraceRecord {
+	return rp.records
+}
+
+func (rp *Replayer) SetReadTimeout(timeout time.Duration) {
+	rp.readtimeout = timeout
+}
+
+// SetRealtime keeps the delays between records as recorded
+func (rp *Replayer) SetRealtime(enabled bool) {
+	rp.realtime = enabled
+}
+
+// Replay plays the peer of the recorded Fstrm on conn: frames read by the
+// recorded Fstrm are written, frames it wrote are expected and compared.
+func (rp *Replayer) Replay(conn net.Conn) error {
+	peer := NewFstrm(bufio.NewReader(conn), bufio.NewWriter(conn), conn, rp.readtimeout, nil, false)
+	peer.SetDataFrameMaxLength(^uint32(0))
+	peer.SetControlFrameMaxLength(^uint32(0))
+
+	var last time.Time
+	for _, record := range rp.records {
+		if rp.realtime && !last.IsZero() {
+			time.Sleep(record.Time.Sub(last))
+		}
+		last = record.Time
+
+		switch record.Direction {
+		case DirectionRead:
+			if _, err := peer.writer.Write(record.Frame); err != nil {
+				return err
+			}
+			if err := peer.writer.Flush(); err != nil {
+				return err
+			}
+		case DirectionWrite:
+			frame, err := peer.readFrame(true)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(wireBytes(frame), record.Frame) {
+				return ErrTraceMismatch
+			}
+		default:
+			return ErrTraceMalformed
+		}
+	}
+	return nil
+}
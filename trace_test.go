@@ -0,0 +1,144 @@
+package framestream
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func recordReceiverSession(t *testing.T) []byte {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		fs_server := NewFstrm(bufio.NewReader(server), bufio.NewWriter(server), server, 5*time.Second, []byte("frstrm"), true)
+		if err := fs_server.InitSender(); err != nil {
+			t.Errorf("error to init framestream sender: %s", err)
+			return
+		}
+		frame := &Frame{}
+		frame.Write([]byte{1, 2, 3, 4})
+		if err := fs_server.SendFrame(frame); err != nil {
+			t.Errorf("error to send frame: %s", err)
+		}
+	}()
+
+	trace := new(bytes.Buffer)
+	rec, err := NewRecorder(trace)
+	if err != nil {
+		t.Fatalf("error to create recorder: %s", err)
+	}
+
+	fs_client := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 5*time.Second, []byte("frstrm"), true)
+	fs_client.SetRecorder(rec)
+	if err := fs_client.InitReceiver(); err != nil {
+		t.Fatalf("error to init framestream receiver: %s", err)
+	}
+	if _, err := fs_client.RecvFrame(true); err != nil {
+		t.Fatalf("error to receive frame: %s", err)
+	}
+	return trace.Bytes()
+}
+
+func TestTrace_Record(t *testing.T) {
+	trace := recordReceiverSession(t)
+
+	tr, err := NewTraceReader(bytes.NewReader(trace))
+	if err != nil {
+		t.Fatalf("error to read trace: %s", err)
+	}
+
+	// READY, ACCEPT, START, data
+	expected := []Direction{DirectionRead, DirectionWrite, DirectionRead, DirectionRead}
+	for i, direction := range expected {
+		record, err := tr.Next()
+		if err != nil {
+			t.Fatalf("error to read record %d: %s", i, err)
+		}
+		if record.Direction != direction {
+			t.Errorf("record %d: expected direction %s, got %s", i, direction, record.Direction)
+		}
+	}
+	record, err := tr.Next()
+	if err != io.EOF {
+		t.Fatalf("expected end of trace, got %v", err)
+	}
+	if record != nil {
+		t.Fatalf("unexpected record")
+	}
+}
+
+func TestTrace_Replay(t *testing.T) {
+	replayer, err := NewReplayer(bytes.NewReader(recordReceiverSession(t)))
+	if err != nil {
+		t.Fatalf("error to load trace: %s", err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- replayer.Replay(server) }()
+
+	fs := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 5*time.Second, []byte("frstrm"), true)
+	if err := fs.InitReceiver(); err != nil {
+		t.Fatalf("error to init framestream receiver: %s", err)
+	}
+	frame, err := fs.RecvFrame(true)
+	if err != nil {
+		t.Fatalf("error to receive frame: %s", err)
+	}
+	if !bytes.Equal(frame.Data(), []byte{1, 2, 3, 4}) {
+		t.Errorf("unexpected data: %v", frame.Data())
+	}
+	if err := <-done; err != nil {
+		t.Errorf("error to replay trace: %s", err)
+	}
+}
+
+func TestTrace_ReplayMismatch(t *testing.T) {
+	replayer, err := NewReplayer(bytes.NewReader(recordReceiverSession(t)))
+	if err != nil {
+		t.Fatalf("error to load trace: %s", err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- replayer.Replay(server) }()
+
+	// receiver answering with another content type
+	fs := NewFstrm(bufio.NewReader(client), bufio.NewWriter(client), client, 5*time.Second, []byte("frstrm"), true)
+	go fs.SendControl(&ControlFrame{ctype: CONTROL_ACCEPT, ctypes: [][]byte{[]byte("other")}})
+	fs.RecvFrame(true)
+
+	if err := <-done; !errors.Is(err, ErrTraceMismatch) {
+		t.Errorf("expected ErrTraceMismatch, got %v", err)
+	}
+}
+
+func TestTrace_Malformed(t *testing.T) {
+	if _, err := NewTraceReader(bytes.NewReader([]byte("FSTRM"))); !errors.Is(err, ErrTraceMalformed) {
+		t.Errorf("expected ErrTraceMalformed, got %v", err)
+	}
+
+	// corrupt record length
+	trace := append(append([]byte{}, traceMagic...), traceVersion, byte(DirectionRead))
+	trace = append(trace, make([]byte, 8)...)
+	trace = append(trace, 0xff, 0xff, 0xff, 0xff)
+	tr, err := NewTraceReader(bytes.NewReader(trace))
+	if err != nil {
+		t.Fatalf("error to read trace: %s", err)
+	}
+	if _, err := tr.Next(); !errors.Is(err, ErrTraceMalformed) {
+		t.Errorf("expected ErrTraceMalformed, got %v", err)
+	}
+}
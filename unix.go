@@ -0,0 +1,166 @@
+package framestream
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrSocketInUse = errors.New("unix socket in use")
+var ErrSocketNotSocket = errors.New("path exists and is not a unix socket")
+var ErrAbstractSocketUnsupported = errors.New("abstract unix sockets not supported on this platform")
+
+/* Credentials of the process connected to a unix socket */
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+/*
+Unix listener options
+
+Paths starting with @ are Linux abstract sockets, they have no
+file on disk so RemoveStale, Mode and Chown don't apply.
+*/
+type UnixListenerOptions struct {
+	// remove a socket file left by a previous process
+	RemoveStale bool
+	// file mode of the socket, unchanged if zero
+	Mode os.FileMode
+	// change the owner and group of the socket to UID and GID
+	Chown bool
+	UID   int
+	GID   int
+}
+
+/* Unix listener, accepted connections carry the peer credentials */
+type UnixListener struct {
+	*net.UnixListener
+	path string
+	// socket file linked into place, removed by Close
+	unlink bool
+}
+
+/* Unix connection with the credentials of the peer, when available */
+type UnixConn struct {
+	*net.UnixConn
+	creds *PeerCredentials
+}
+
+func (c *UnixConn) PeerCredentials() *PeerCredentials {
+	return c.creds
+}
+
+func isAbstractSocket(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+func ListenUnix(path string, opts UnixListenerOptions) (*UnixListener, error) {
+	abstract := isAbstractSocket(path)
+	if abstract && !abstractSocketSupported {
+		return nil, ErrAbstractSocketUnsupported
+	}
+
+	if !abstract && opts.RemoveStale {
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// the mode and owner are set before the socket is reachable at its path
+	if !abstract && (opts.Mode != 0 || opts.Chown) {
+		return listenUnixPrivate(path, opts)
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	return &UnixListener{UnixListener: listener, path: path}, nil
+}
+
+// listenUnixPrivate creates the socket in a private directory next to path,
+// applies the mode and owner, then links it into place
+func listenUnixPrivate(path string, opts UnixListenerOptions) (*UnixListener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".fstrm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(false)
+
+	if opts.Mode != 0 {
+		err = os.Chmod(tmp, opts.Mode)
+	}
+	if err == nil && opts.Chown {
+		err = os.Chown(tmp, opts.UID, opts.GID)
+	}
+	// unlike a rename, the link fails if the path exists
+	if err == nil {
+		err = os.Link(tmp, path)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &UnixListener{UnixListener: listener, path: path, unlink: true}, nil
+}
+
+// removeStaleSocket removes the socket file if no process listens on it
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return ErrSocketNotSocket
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return ErrSocketInUse
+	}
+	return os.Remove(path)
+}
+
+// Accept returns a *UnixConn holding the credentials of the peer
+func (l *UnixListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptUnix()
+	if err != nil {
+		return nil, err
+	}
+	creds, _ := peerCredentials(conn)
+	return &UnixConn{UnixConn: conn, creds: creds}, nil
+}
+
+// Close stops the listener and removes its socket file
+func (l *UnixListener) Close() error {
+	err := l.UnixListener.Close()
+	if l.unlink && err == nil {
+		os.Remove(l.path)
+	}
+	return err
+}
+
+func (l *UnixListener) Path() string {
+	return l.path
+}
+
+// PeerCredentials returns the credentials of the process connected
+// to the unix socket of the session, or nil
+func (fs *Fstrm) PeerCredentials() *PeerCredentials {
+	return fs.peerCreds
+}
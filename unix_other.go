@@ -0,0 +1,14 @@
+//go:build !linux
+
+package framestream
+
+import (
+	"errors"
+	"net"
+)
+
+const abstractSocketSupported = false
+
+func peerCredentials(conn *net.UnixConn) (*PeerCredentials, error) {
+	return nil, errors.New("peer credentials not supported on this platform")
+}
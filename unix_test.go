@@ -0,0 +1,150 @@
+package framestream
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestListenUnix_RemoveStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fstrm.sock")
+
+	// socket file left by a crashed process
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("error to listen: %s", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	if _, err := ListenUnix(path, UnixListenerOptions{}); err == nil {
+		t.Fatalf("expected error on stale socket")
+	}
+
+	l, err := ListenUnix(path, UnixListenerOptions{RemoveStale: true, Mode: 0o660})
+	if err != nil {
+		t.Fatalf("error to listen with stale removal: %s", err)
+	}
+	defer l.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("error to stat socket: %s", err)
+	}
+	if info.Mode().Perm() != 0o660 {
+		t.Errorf("unexpected socket mode: %s", info.Mode())
+	}
+
+	// socket in use
+	if _, err := ListenUnix(path, UnixListenerOptions{RemoveStale: true}); !errors.Is(err, ErrSocketInUse) {
+		t.Errorf("expected ErrSocketInUse, got %v", err)
+	}
+}
+
+func TestListenUnix_NotSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(path, []byte("data"), 0o644)
+
+	if _, err := ListenUnix(path, UnixListenerOptions{RemoveStale: true}); !errors.Is(err, ErrSocketNotSocket) {
+		t.Errorf("expected ErrSocketNotSocket, got %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("regular file removed")
+	}
+}
+
+func TestListenUnix_ModeBeforeLink(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fstrm.sock")
+
+	// an existing path is never replaced
+	os.WriteFile(path, []byte("data"), 0o644)
+	if _, err := ListenUnix(path, UnixListenerOptions{Mode: 0o600}); err == nil {
+		t.Fatalf("expected error on existing path")
+	}
+	os.Remove(path)
+
+	l, err := ListenUnix(path, UnixListenerOptions{Mode: 0o600})
+	if err != nil {
+		t.Fatalf("error to listen: %s", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected socket file: %v %v", info, err)
+	}
+	if conn, err := net.Dial("unix", path); err != nil {
+		t.Errorf("error to dial: %s", err)
+	} else {
+		conn.Close()
+	}
+
+	// no private directory left, socket removed on close
+	l.Close()
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("unexpected files left: %v", entries)
+	}
+}
+
+func testUnixSession(t *testing.T, path string) {
+	l, err := ListenUnix(path, UnixListenerOptions{RemoveStale: true})
+	if err != nil {
+		t.Fatalf("error to listen: %s", err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Errorf("error to dial: %s", err)
+			return
+		}
+		defer conn.Close()
+		fs := NewFstrm(bufio.NewReader(conn), bufio.NewWriter(conn), conn, time.Second, []byte("ctype"), true)
+		if err := fs.InitSender(); err != nil {
+			t.Errorf("error to init sender: %s", err)
+		}
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("error to accept: %s", err)
+	}
+	defer conn.Close()
+
+	fs := NewFstrm(bufio.NewReader(conn), bufio.NewWriter(conn), conn, time.Second, []byte("ctype"), true)
+	if err := fs.InitReceiver(); err != nil {
+		t.Fatalf("error to init receiver: %s", err)
+	}
+
+	creds := fs.PeerCredentials()
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if creds == nil {
+		t.Fatalf("peer credentials not available")
+	}
+	if int(creds.PID) != os.Getpid() || int(creds.UID) != os.Getuid() {
+		t.Errorf("unexpected peer credentials: %+v", creds)
+	}
+}
+
+func TestListenUnix_PeerCredentials(t *testing.T) {
+	testUnixSession(t, filepath.Join(t.TempDir(), "fstrm.sock"))
+}
+
+func TestListenUnix_Abstract(t *testing.T) {
+	path := fmt.Sprintf("@go-framestream-test-%d", os.Getpid())
+	if runtime.GOOS != "linux" {
+		if _, err := ListenUnix(path, UnixListenerOptions{}); !errors.Is(err, ErrAbstractSocketUnsupported) {
+			t.Errorf("expected ErrAbstractSocketUnsupported, got %v", err)
+		}
+		return
+	}
+	testUnixSession(t, path)
+}